@@ -0,0 +1,314 @@
+// Package cassette implements an http.RoundTripper that records HTTP
+// traffic to a cassette file and replays it, e.g. for running tests
+// offline or sharing captured payloads in bug reports.
+//
+// Secrets (tokens, API keys, passwords, etc.) and personal information
+// (email addresses, names, user IDs, etc.) are redacted before the
+// cassette is written. Requests are matched by method, path, query
+// and (redacted) body, in the order they were recorded.
+//
+// Example usage with ocpapi (the transport is also used for Gigya):
+//
+//	rec, err := cassette.New("testdata/login.json", cassette.ModeReplay, nil)
+//	if err != nil {
+//		// ...
+//	}
+//	defer rec.Close()
+//	client, err := ocpapi.New(config, ocpapi.WithTransport(rec))
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/mafredri/electrolux-ocp/internal/httplog"
+)
+
+// Version is the cassette file format version.
+const Version = 1
+
+// Mode is the recorder mode.
+type Mode int
+
+const (
+	// ModeReplay replays recorded interactions, requests that do not
+	// match an interaction fail with ErrNoMatch.
+	ModeReplay Mode = iota
+	// ModeRecord performs requests and records the interactions, the
+	// cassette is written (overwritten) by Close.
+	ModeRecord
+)
+
+// ErrNoMatch is returned when replaying a request that does not match
+// any (unused) recorded interaction.
+var ErrNoMatch = errors.New("cassette: no matching interaction")
+
+// redactor redacts secrets and personal information, cassettes are
+// meant to be shared.
+var redactor = httplog.Redactor{PII: true}
+
+// volatileKeys are form keys that change between requests (e.g. due
+// to request signing) and are ignored when matching.
+var volatileKeys = []string{"nonce", "timestamp"}
+
+// Cassette is the recorded HTTP traffic.
+type Cassette struct {
+	Version      int           `json:"version"`
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is a recorded request and response.
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+// Request is a recorded request.
+type Request struct {
+	Method string      `json:"method"`
+	Host   string      `json:"host"`
+	Path   string      `json:"path"`
+	Query  string      `json:"query,omitempty"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+// Response is a recorded response.
+type Response struct {
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+}
+
+// Load reads the cassette file at path.
+func Load(path string) (*Cassette, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var c Cassette
+	err = json.Unmarshal(b, &c)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal: %w", err)
+	}
+	if c.Version != Version {
+		return nil, fmt.Errorf("unsupported cassette version %d", c.Version)
+	}
+
+	return &c, nil
+}
+
+// Save writes the cassette file to path.
+func (c *Cassette) Save(path string) error {
+	b, err := json.MarshalIndent(c, "", "\t")
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+	return os.WriteFile(path, append(b, '\n'), 0o644)
+}
+
+// Recorder is an http.RoundTripper that records or replays requests.
+// It is safe for concurrent use.
+type Recorder struct {
+	path string
+	mode Mode
+	rt   http.RoundTripper
+
+	mu       sync.Mutex
+	cassette *Cassette
+	used     []bool // Replayed interactions.
+}
+
+var _ http.RoundTripper = (*Recorder)(nil)
+
+// New returns a recorder for the cassette file at path. In replay
+// mode the cassette is loaded, in record mode requests are performed
+// using rt (http.DefaultTransport if nil).
+func New(path string, mode Mode, rt http.RoundTripper) (*Recorder, error) {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	r := &Recorder{
+		path:     path,
+		mode:     mode,
+		rt:       rt,
+		cassette: &Cassette{Version: Version},
+	}
+
+	switch mode {
+	case ModeReplay:
+		c, err := Load(path)
+		if err != nil {
+			return nil, err
+		}
+		r.cassette = c
+		r.used = make([]bool, len(c.Interactions))
+	case ModeRecord:
+	default:
+		return nil, fmt.Errorf("unknown mode %d", mode)
+	}
+
+	return r, nil
+}
+
+// Close saves the cassette in record mode.
+func (r *Recorder) Close() error {
+	if r.mode != ModeRecord {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.cassette.Save(r.path)
+}
+
+// RoundTrip implements http.RoundTripper.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	out, rec, err := newRequest(req)
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+
+	if r.mode == ModeReplay {
+		if out.Body != nil {
+			out.Body.Close()
+		}
+		return r.replay(req, rec)
+	}
+	return r.record(out, rec)
+}
+
+func (r *Recorder) replay(req *http.Request, rec Request) (*http.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, in := range r.cassette.Interactions {
+		if r.used[i] || !rec.matches(in.Request) {
+			continue
+		}
+		r.used[i] = true
+
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", in.Response.StatusCode, http.StatusText(in.Response.StatusCode)),
+			StatusCode:    in.Response.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        in.Response.Header.Clone(),
+			Body:          io.NopCloser(strings.NewReader(in.Response.Body)),
+			ContentLength: int64(len(in.Response.Body)),
+			Request:       req,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s %s", ErrNoMatch, req.Method, req.URL.Path)
+}
+
+func (r *Recorder) record(req *http.Request, rec Request) (*http.Response, error) {
+	resp, err := r.rt.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(b))
+
+	header := httplog.RedactHeader(resp.Header)
+	header.Del("Content-Length") // The body may change due to redaction.
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cassette.Interactions = append(r.cassette.Interactions, Interaction{
+		Request: rec,
+		Response: Response{
+			StatusCode: resp.StatusCode,
+			Header:     header,
+			Body:       redactor.Body(resp.Header.Get("Content-Type"), b),
+		},
+	})
+
+	return resp, nil
+}
+
+// newRequest returns the request to send and the redacted and
+// normalized recording of req. If the body can not be read via
+// GetBody, it is consumed and a clone of req with a copy of the body
+// is returned, since RoundTrip must not modify req.
+func newRequest(req *http.Request) (*http.Request, Request, error) {
+	rec := Request{
+		Method: req.Method,
+		Host:   req.URL.Host,
+		Path:   req.URL.Path,
+		Query:  normalizeForm(req.URL.RawQuery),
+		Header: httplog.RedactHeader(req.Header),
+	}
+
+	if req.Body != nil && req.Body != http.NoBody {
+		body := req.Body
+		if req.GetBody != nil {
+			var err error
+			body, err = req.GetBody()
+			if err != nil {
+				return nil, Request{}, fmt.Errorf("read request body: %w", err)
+			}
+		}
+		b, err := io.ReadAll(body)
+		body.Close()
+		if err != nil {
+			return nil, Request{}, fmt.Errorf("read request body: %w", err)
+		}
+		if req.GetBody == nil {
+			req = req.Clone(req.Context())
+			req.Body = io.NopCloser(bytes.NewReader(b))
+			req.GetBody = func() (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewReader(b)), nil
+			}
+		}
+
+		contentType := req.Header.Get("Content-Type")
+		rec.Body = redactor.Body(contentType, b)
+		if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+			rec.Body = normalizeForm(rec.Body)
+		}
+	}
+
+	return req, rec, nil
+}
+
+// normalizeForm redacts, removes volatile keys and sorts the form (or
+// query) encoded values.
+func normalizeForm(s string) string {
+	if s == "" {
+		return ""
+	}
+	form, err := url.ParseQuery(redactor.Form(s))
+	if err != nil {
+		return s
+	}
+	for _, k := range volatileKeys {
+		form.Del(k)
+	}
+	return form.Encode()
+}
+
+func (r Request) matches(o Request) bool {
+	return r.Method == o.Method && r.Path == o.Path && r.Query == o.Query && r.Body == o.Body
+}
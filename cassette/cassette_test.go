@@ -0,0 +1,139 @@
+package cassette
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/one-account-user/api/v1/identity-providers":
+			fmt.Fprint(w, `[{"domain": "eu1.gigya.com", "apiKey": "gigya-api-key"}]`)
+		case "/accounts.login":
+			fmt.Fprint(w, `{"errorCode": 0, "UID": "uid-1", "profile": {"email": "user@example.com", "firstName": "Firstname"}, "emails": {"verified": ["user@example.com"]}, "sessionInfo": {"sessionToken": "session-token"}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// doRequests performs the test requests and returns the response
+// bodies.
+func doRequests(t *testing.T, rt http.RoundTripper, baseURL string) []string {
+	t.Helper()
+
+	client := &http.Client{Transport: rt}
+	var bodies []string
+	for _, r := range []struct {
+		method, path, form string
+	}{
+		{http.MethodGet, "/one-account-user/api/v1/identity-providers?brand=electrolux&email=user%40example.com", ""},
+		{http.MethodPost, "/accounts.login", url.Values{"loginID": {"user@example.com"}, "password": {"hunter2"}, "nonce": {"1"}}.Encode()},
+	} {
+		req, err := http.NewRequest(r.method, baseURL+r.path, strings.NewReader(r.form))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.form != "" {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", r.method, r.path, err)
+		}
+		b, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		bodies = append(bodies, string(b))
+	}
+	return bodies
+}
+
+func TestRecorder_RecordReplayRedacted(t *testing.T) {
+	srv := newTestServer(t)
+	path := filepath.Join(t.TempDir(), "cassette.json")
+
+	rec, err := New(path, ModeRecord, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doRequests(t, rec, srv.URL)
+	if err = rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"user@example.com", "user%40example.com", "Firstname", "uid-1", "hunter2", "session-token"} {
+		if strings.Contains(string(b), s) {
+			t.Errorf("cassette contains %q:\n%s", s, b)
+		}
+	}
+
+	// The redacted requests still match when replayed.
+	rec, err = New(path, ModeReplay, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bodies := doRequests(t, rec, "http://127.0.0.1:1")
+	if !strings.Contains(bodies[0], "eu1.gigya.com") || !strings.Contains(bodies[1], `"UID":"REDACTED"`) {
+		t.Errorf("replayed bodies = %q", bodies)
+	}
+
+	// All interactions have been used.
+	_, err = rec.RoundTrip(httptest.NewRequest(http.MethodPost, "http://127.0.0.1:1/accounts.login", nil))
+	if err == nil {
+		t.Error("RoundTrip: want ErrNoMatch")
+	}
+}
+
+func TestRecorder_DoesNotModifyRequest(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got = string(b)
+	}))
+	t.Cleanup(srv.Close)
+
+	rec, err := New(filepath.Join(t.TempDir(), "cassette.json"), ModeRecord, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without GetBody, the body can only be read once.
+	body := io.NopCloser(strings.NewReader(`{"key": "value"}`))
+	req, err := http.NewRequest(http.MethodPost, srv.URL, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := rec.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if req.Body != body || req.GetBody != nil {
+		t.Error("RoundTrip modified the request")
+	}
+	if want := `{"key": "value"}`; got != want {
+		t.Errorf("server received body %q, want %q", got, want)
+	}
+	if b := rec.cassette.Interactions[0].Request.Body; b != `{"key":"value"}` {
+		t.Errorf("recorded body = %q", b)
+	}
+}
@@ -0,0 +1,64 @@
+package gigya
+
+import (
+	"context"
+	"net/url"
+)
+
+// Account is a Gigya account. Timestamps are in milliseconds since
+// epoch, the corresponding strings are ISO 8601 formatted.
+type Account struct {
+	UID                        string  `json:"UID"`
+	UIDSignature               string  `json:"UIDSignature"`
+	SignatureTimestamp         string  `json:"signatureTimestamp"`
+	Created                    string  `json:"created"`
+	CreatedTimestamp           int64   `json:"createdTimestamp"`
+	IsActive                   bool    `json:"isActive"`
+	IsRegistered               bool    `json:"isRegistered"`
+	IsVerified                 bool    `json:"isVerified"` // Whether the email is verified.
+	LastLogin                  string  `json:"lastLogin"`
+	LastLoginTimestamp         int64   `json:"lastLoginTimestamp"`
+	LastUpdated                string  `json:"lastUpdated"`
+	LastUpdatedTimestamp       int64   `json:"lastUpdatedTimestamp"`
+	LoginProvider              string  `json:"loginProvider"` // Example: "site".
+	OldestDataUpdated          string  `json:"oldestDataUpdated"`
+	OldestDataUpdatedTimestamp int64   `json:"oldestDataUpdatedTimestamp"`
+	Profile                    Profile `json:"profile"`
+	Emails                     *Emails `json:"emails,omitempty"` // Set by AccountInfo.
+	Registered                 string  `json:"registered"`
+	RegisteredTimestamp        int64   `json:"registeredTimestamp"`
+	SocialProviders            string  `json:"socialProviders"`
+	Verified                   string  `json:"verified"`
+	VerifiedTimestamp          int64   `json:"verifiedTimestamp"`
+}
+
+// Profile is the profile of a Gigya account.
+type Profile struct {
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	City      string `json:"city"`
+	Country   string `json:"country"`
+	Email     string `json:"email"`
+	Zip       string `json:"zip"`
+}
+
+// Emails are the verified and unverified email addresses of an
+// account.
+type Emails struct {
+	Verified   []string `json:"verified"`
+	Unverified []string `json:"unverified"`
+}
+
+// AccountInfo returns the account of the session.
+func (v *Identity) AccountInfo(ctx context.Context, s Session) (Account, error) {
+	var res accountInfoResponse
+	err := v.callSession(ctx, "accounts.getAccountInfo", s, url.Values{
+		"include":   []string{"profile,emails,loginIDs"},
+		"targetEnv": []string{"mobile"},
+	}, &res)
+	if err != nil {
+		return Account{}, err
+	}
+
+	return res.Account, nil
+}
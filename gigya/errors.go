@@ -0,0 +1,63 @@
+package gigya
+
+import "fmt"
+
+// Errors returned by the Gigya API, use errors.Is to match them
+// against an error returned by Identity.
+var (
+	ErrInvalidLogin           = &Error{Code: 403042, Message: "invalid login"}
+	ErrAccountDisabled        = &Error{Code: 403041, Message: "account disabled"}
+	ErrAccountLocked          = &Error{Code: 403120, Message: "account temporarily locked out"}
+	ErrPendingRegistration    = &Error{Code: 206001, Message: "account pending registration"}
+	ErrPendingVerification    = &Error{Code: 206002, Message: "account pending verification"}
+	ErrPendingTFAVerification = &Error{Code: 403101, Message: "account pending TFA verification"}
+	ErrPendingTFARegistration = &Error{Code: 403102, Message: "account pending TFA registration"}
+	ErrInvalidDataCenter      = &Error{Code: 301001, Message: "invalid data center"}
+	ErrRateLimited            = &Error{Code: 403048, Message: "rate limit exceeded"}
+)
+
+// Error is an error response from the Gigya API.
+type Error struct {
+	Method       string // Example: "accounts.login".
+	CallID       string
+	Code         int // Gigya error code, e.g. 403042.
+	Message      string
+	Details      string
+	StatusCode   int // HTTP status code equivalent.
+	StatusReason string
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("%s (%d)", e.Message, e.Code)
+	if e.Details != "" && e.Details != e.Message {
+		msg += ": " + e.Details
+	}
+	if e.Method != "" {
+		msg = e.Method + ": " + msg
+	}
+	return msg
+}
+
+// Is reports whether target is an *Error with the same error code,
+// this allows matching against e.g. ErrInvalidLogin.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// err returns an *Error for the response, or nil if the response
+// does not contain an error.
+func (r *response) err(method string) error {
+	if r.ErrorCode == 0 {
+		return nil
+	}
+	return &Error{
+		Method:       method,
+		CallID:       r.CallID,
+		Code:         r.ErrorCode,
+		Message:      r.ErrorMessage,
+		Details:      r.ErrorDetails,
+		StatusCode:   r.StatusCode,
+		StatusReason: r.StatusReason,
+	}
+}
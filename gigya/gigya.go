@@ -4,15 +4,35 @@ package gigya
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
+
+	"github.com/mafredri/electrolux-ocp/internal/httplog"
 )
 
 type Config struct {
 	Domain string
 	APIKey string
+
+	// HTTPClient is used for requests. Optional, defaults to a client
+	// with a 30 second timeout.
+	HTTPClient *http.Client
+	// BaseURL overrides the accounts API URL, by default it is
+	// derived from Domain ("https://accounts.<Domain>"). Optional.
+	BaseURL string
+	// Logger logs requests at debug level. Optional.
+	Logger *slog.Logger
+	// LogBodies enables logging of (redacted) headers and bodies.
+	LogBodies bool
+
+	// TFA completes two-factor authentication when the account
+	// requires it. Optional, login fails for such accounts if unset.
+	TFA TFAHandler
 }
 
 type Identity struct {
@@ -21,19 +41,40 @@ type Identity struct {
 }
 
 func NewIdentity(config Config) *Identity {
+	if config.BaseURL == "" {
+		config.BaseURL = fmt.Sprintf("https://accounts.%s", config.Domain)
+	}
+	config.BaseURL = strings.TrimSuffix(config.BaseURL, "/")
+
+	client := config.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	if config.Logger != nil {
+		c := *client
+		rt := c.Transport
+		if rt == nil {
+			rt = http.DefaultTransport
+		}
+		c.Transport = &httplog.Transport{RT: rt, Logger: config.Logger, LogBodies: config.LogBodies}
+		client = &c
+	}
+
 	return &Identity{
 		config: config,
-		client: http.DefaultClient,
+		client: client,
 	}
 }
 
+// Login logs in using the provided credentials and returns an ID
+// token (JWT). Use LoginSession to keep the session for later use.
 func (v *Identity) Login(ctx context.Context, user, password string) (jwtToken string, err error) {
-	l, err := v.login(ctx, user, password)
+	s, _, err := v.LoginSession(ctx, user, password)
 	if err != nil {
 		return "", err
 	}
 
-	jt, err := v.jwtToken(ctx, l.Uid, l.SessionInfo.SessionToken, l.SessionInfo.SessionSecret)
+	jt, err := v.JWT(ctx, s)
 	if err != nil {
 		return "", err
 	}
@@ -42,71 +83,74 @@ func (v *Identity) Login(ctx context.Context, user, password string) (jwtToken s
 }
 
 func (v *Identity) login(ctx context.Context, user, password string) (loginResponse, error) {
-	form := url.Values{
-		"apikey":          []string{v.config.APIKey},
-		"format":          []string{"json"},
-		"httpStatusCodes": []string{"false"},
-		"loginID":         []string{user},
-		"password":        []string{password},
-		"targetEnv":       []string{"mobile"},
+	var res loginResponse
+	err := v.call(ctx, "accounts.login", url.Values{
+		"loginID":   []string{user},
+		"password":  []string{password},
+		"targetEnv": []string{"mobile"},
+	}, &res)
+	if errors.Is(err, ErrPendingTFAVerification) && v.config.TFA != nil {
+		res, err = v.tfa(ctx, res.RegToken)
+		if err != nil {
+			return loginResponse{}, fmt.Errorf("tfa: %w", err)
+		}
+		return res, nil
 	}
-	uri := fmt.Sprintf("https://accounts.%s/accounts.login", v.config.Domain)
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, uri, strings.NewReader(form.Encode()))
 	if err != nil {
 		return loginResponse{}, err
 	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	resp, err := v.client.Do(req)
-	if err != nil {
-		return loginResponse{}, fmt.Errorf("do request: %w", err)
-	}
-	defer resp.Body.Close()
+	return res, nil
+}
 
-	var res loginResponse
-	err = json.NewDecoder(resp.Body).Decode(&res)
-	if err != nil {
-		return loginResponse{}, fmt.Errorf("decode response: %w", err)
-	}
-	if res.ErrorCode > 0 {
-		return loginResponse{}, fmt.Errorf("login: %s", res.ErrorMessage)
-	}
+// apiResponse is implemented by all response types (via response).
+type apiResponse interface {
+	err(method string) error
+}
 
-	return res, nil
+// call posts the form to the Gigya API method and decodes the
+// response into res. The API key and common parameters are added to
+// the form. If the response contains an error, an *Error is returned
+// (res is still populated).
+func (v *Identity) call(ctx context.Context, method string, form url.Values, res apiResponse) error {
+	return v.post(ctx, method, form, "", res)
+}
+
+// callSession is like call but authenticates the request with the
+// session, the request is signed using the session secret.
+func (v *Identity) callSession(ctx context.Context, method string, s Session, form url.Values, res apiResponse) error {
+	form.Set("oauth_token", s.Token)
+	return v.post(ctx, method, form, s.Secret, res)
 }
 
-func (v *Identity) jwtToken(ctx context.Context, uid, sessionToken, sessionSecret string) (string, error) {
-	form := url.Values{
-		"apikey":          []string{v.config.APIKey},
-		"fields":          []string{"country"},
-		"format":          []string{"json"},
-		"httpStatusCodes": []string{"false"},
-		"targetUID":       []string{uid},
-		"oauth_token":     []string{sessionToken},
-		"secret":          []string{sessionSecret},
-		"targetEnv":       []string{"mobile"},
+func (v *Identity) post(ctx context.Context, method string, form url.Values, secret string, res apiResponse) error {
+	form.Set("apikey", v.config.APIKey)
+	form.Set("format", "json")
+	form.Set("httpStatusCodes", "false")
+
+	uri := fmt.Sprintf("%s/%s", v.config.BaseURL, method)
+	if secret != "" {
+		if err := signForm(http.MethodPost, uri, form, secret); err != nil {
+			return fmt.Errorf("sign request: %w", err)
+		}
 	}
-	uri := fmt.Sprintf("https://accounts.%s/accounts.getJWT", v.config.Domain)
+
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, uri, strings.NewReader(form.Encode()))
 	if err != nil {
-		return "", err
+		return err
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
 	resp, err := v.client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("do request: %w", err)
+		return fmt.Errorf("do request: %w", err)
 	}
 	defer resp.Body.Close()
 
-	var res jwtResponse
-	err = json.NewDecoder(resp.Body).Decode(&res)
+	err = json.NewDecoder(resp.Body).Decode(res)
 	if err != nil {
-		return "", fmt.Errorf("decode response: %w", err)
-	}
-	if res.ErrorCode > 0 {
-		return "", fmt.Errorf("jwtToken: %s", res.ErrorMessage)
+		return fmt.Errorf("decode response: %w", err)
 	}
 
-	return res.IDToken, err
+	return res.err(method)
 }
@@ -0,0 +1,84 @@
+package gigya
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// NewAccount contains the information for registering an account.
+type NewAccount struct {
+	Email    string
+	Password string
+	Profile  Profile // Optional, Profile.Email defaults to Email.
+	Lang     string  // Optional, language of emails sent to the user, e.g. "en".
+}
+
+// Registration is the result of Register.
+type Registration struct {
+	UID string
+	// PendingVerification is set if the account has been created but
+	// the email must be verified before logging in.
+	PendingVerification bool
+	// Session is set when the registration was completed.
+	Session *Session
+}
+
+// Register registers a new account. Errors are returned as *Error,
+// like for login.
+func (v *Identity) Register(ctx context.Context, a NewAccount) (Registration, error) {
+	if a.Profile.Email == "" {
+		a.Profile.Email = a.Email
+	}
+	profile, err := json.Marshal(a.Profile)
+	if err != nil {
+		return Registration{}, fmt.Errorf("marshal profile: %w", err)
+	}
+
+	var init initRegistrationResponse
+	err = v.call(ctx, "accounts.initRegistration", url.Values{}, &init)
+	if err != nil {
+		return Registration{}, err
+	}
+
+	now := time.Now()
+	form := url.Values{
+		"email":                []string{a.Email},
+		"password":             []string{a.Password},
+		"regToken":             []string{init.RegToken},
+		"profile":              []string{string(profile)},
+		"finalizeRegistration": []string{"true"},
+		"targetEnv":            []string{"mobile"},
+	}
+	if a.Lang != "" {
+		form.Set("lang", a.Lang)
+	}
+	var res loginResponse
+	err = v.call(ctx, "accounts.register", form, &res)
+	if errors.Is(err, ErrPendingVerification) {
+		return Registration{UID: res.UID, PendingVerification: true}, nil
+	}
+	if err != nil {
+		return Registration{}, err
+	}
+
+	s := newSession(now, res.UID, res.SessionInfo)
+	return Registration{UID: res.UID, Session: &s}, nil
+}
+
+// ResetPassword sends a password reset email to the account with the
+// login ID (e.g. email). The lang is optional.
+func (v *Identity) ResetPassword(ctx context.Context, loginID, lang string) error {
+	form := url.Values{
+		"loginID": []string{loginID},
+	}
+	if lang != "" {
+		form.Set("lang", lang)
+	}
+
+	var res response
+	return v.call(ctx, "accounts.resetPassword", form, &res)
+}
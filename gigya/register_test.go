@@ -0,0 +1,153 @@
+package gigya
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// standIn is a local Gigya stand-in that responds to API methods with
+// canned JSON responses and records the received forms.
+type standIn struct {
+	mu    sync.Mutex
+	forms map[string]url.Values // By API method.
+}
+
+func newStandIn(t *testing.T, responses map[string]string) (*Identity, *standIn) {
+	s := &standIn{forms: make(map[string]url.Values)}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method := strings.TrimPrefix(r.URL.Path, "/")
+		if err := r.ParseForm(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		s.forms[method] = r.PostForm
+		s.mu.Unlock()
+
+		res, ok := responses[method]
+		if !ok {
+			res = fmt.Sprintf(`{"errorCode": 400096, "errorMessage": "Not supported", "statusCode": 400, "callId": %q}`, method)
+		}
+		fmt.Fprint(w, res)
+	}))
+	t.Cleanup(srv.Close)
+
+	return NewIdentity(Config{Domain: "eu1.gigya.com", APIKey: "api-key", BaseURL: srv.URL}), s
+}
+
+func (s *standIn) form(method string) url.Values {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.forms[method]
+}
+
+func TestRegister(t *testing.T) {
+	gi, s := newStandIn(t, map[string]string{
+		"accounts.initRegistration": `{"errorCode": 0, "statusCode": 200, "regToken": "reg-token"}`,
+		"accounts.register":         `{"errorCode": 0, "statusCode": 200, "UID": "uid", "sessionInfo": {"sessionToken": "session-token", "sessionSecret": "c2VjcmV0", "expires_in": "3600"}}`,
+	})
+
+	reg, err := gi.Register(context.Background(), NewAccount{
+		Email:    "user@example.com",
+		Password: "password",
+		Profile:  Profile{FirstName: "Test"},
+		Lang:     "fi",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reg.UID != "uid" || reg.PendingVerification {
+		t.Errorf("Register = %+v, want UID %q and no pending verification", reg, "uid")
+	}
+	if reg.Session == nil || reg.Session.Token != "session-token" || reg.Session.Secret != "c2VjcmV0" || reg.Session.ExpiresAt.IsZero() {
+		t.Errorf("Register session = %+v, want session-token with expiry", reg.Session)
+	}
+
+	form := s.form("accounts.register")
+	for k, want := range map[string]string{
+		"apikey":               "api-key",
+		"email":                "user@example.com",
+		"regToken":             "reg-token",
+		"finalizeRegistration": "true",
+		"lang":                 "fi",
+	} {
+		if got := form.Get(k); got != want {
+			t.Errorf("register form %s = %q, want %q", k, got, want)
+		}
+	}
+	var profile Profile
+	if err = json.Unmarshal([]byte(form.Get("profile")), &profile); err != nil {
+		t.Fatalf("register form profile: %v", err)
+	}
+	if profile.Email != "user@example.com" || profile.FirstName != "Test" {
+		t.Errorf("register form profile = %+v, want email defaulted and first name kept", profile)
+	}
+}
+
+func TestRegister_PendingVerification(t *testing.T) {
+	gi, _ := newStandIn(t, map[string]string{
+		"accounts.initRegistration": `{"errorCode": 0, "statusCode": 200, "regToken": "reg-token"}`,
+		"accounts.register":         `{"errorCode": 206002, "errorMessage": "Account Pending Verification", "statusCode": 206, "UID": "uid"}`,
+	})
+
+	reg, err := gi.Register(context.Background(), NewAccount{Email: "user@example.com", Password: "password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reg.UID != "uid" || !reg.PendingVerification || reg.Session != nil {
+		t.Errorf("Register = %+v, want UID %q pending verification without session", reg, "uid")
+	}
+}
+
+func TestRegister_Error(t *testing.T) {
+	gi, _ := newStandIn(t, map[string]string{
+		"accounts.initRegistration": `{"errorCode": 0, "statusCode": 200, "regToken": "reg-token"}`,
+		"accounts.register":         `{"errorCode": 400009, "errorMessage": "Validation error", "errorDetails": "email already exists", "statusCode": 400, "callId": "call-id"}`,
+	})
+
+	_, err := gi.Register(context.Background(), NewAccount{Email: "user@example.com", Password: "password"})
+	var gerr *Error
+	if !errors.As(err, &gerr) {
+		t.Fatalf("Register: got %v, want *Error", err)
+	}
+	if gerr.Method != "accounts.register" || gerr.Code != 400009 || gerr.CallID != "call-id" || gerr.Details != "email already exists" {
+		t.Errorf("Register error = %+v", gerr)
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	gi, s := newStandIn(t, map[string]string{
+		"accounts.resetPassword": `{"errorCode": 0, "statusCode": 200}`,
+	})
+
+	if err := gi.ResetPassword(context.Background(), "user@example.com", "en"); err != nil {
+		t.Fatal(err)
+	}
+	form := s.form("accounts.resetPassword")
+	if got := form.Get("loginID"); got != "user@example.com" {
+		t.Errorf("loginID = %q, want %q", got, "user@example.com")
+	}
+	if got := form.Get("lang"); got != "en" {
+		t.Errorf("lang = %q, want %q", got, "en")
+	}
+}
+
+func TestResetPassword_Error(t *testing.T) {
+	gi, _ := newStandIn(t, map[string]string{
+		"accounts.resetPassword": `{"errorCode": 403048, "errorMessage": "Rate limit exceeded", "statusCode": 403}`,
+	})
+
+	err := gi.ResetPassword(context.Background(), "user@example.com", "")
+	if !errors.Is(err, ErrRateLimited) {
+		t.Errorf("ResetPassword: got %v, want ErrRateLimited", err)
+	}
+}
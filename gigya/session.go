@@ -0,0 +1,74 @@
+package gigya
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Session is a Gigya login session. It can be kept (e.g. serialized as
+// JSON) and used to mint new ID tokens via Identity.JWT without
+// logging in again.
+type Session struct {
+	UID       string    `json:"uid"`
+	Token     string    `json:"token"`
+	Secret    string    `json:"secret"`
+	ExpiresAt time.Time `json:"expiresAt,omitempty"` // Zero if the session does not expire.
+}
+
+// Expired reports whether the session has expired.
+func (s Session) Expired() bool {
+	return !s.ExpiresAt.IsZero() && time.Now().After(s.ExpiresAt)
+}
+
+func newSession(now time.Time, uid string, si sessionInfo) Session {
+	s := Session{
+		UID:    uid,
+		Token:  si.SessionToken,
+		Secret: si.SessionSecret,
+	}
+	// Non-positive values (e.g. "0" or "-2") mean the session is
+	// valid until revoked.
+	if n, err := strconv.Atoi(si.ExpiresIn); err == nil && n > 0 {
+		s.ExpiresAt = now.Add(time.Duration(n) * time.Second)
+	}
+	return s
+}
+
+// LoginSession logs in using the provided credentials and returns the
+// session and account.
+func (v *Identity) LoginSession(ctx context.Context, user, password string) (Session, Account, error) {
+	now := time.Now()
+	l, err := v.login(ctx, user, password)
+	if err != nil {
+		return Session{}, Account{}, err
+	}
+
+	return newSession(now, l.UID, l.SessionInfo), l.Account, nil
+}
+
+// JWT mints a new ID token (JWT) for the session.
+func (v *Identity) JWT(ctx context.Context, s Session) (string, error) {
+	var res jwtResponse
+	err := v.callSession(ctx, "accounts.getJWT", s, url.Values{
+		"fields":    []string{"country"},
+		"targetUID": []string{s.UID},
+		"targetEnv": []string{"mobile"},
+	}, &res)
+	if err != nil {
+		return "", err
+	}
+
+	return res.IDToken, nil
+}
+
+// Logout logs out of the session, after which it can no longer be
+// used.
+func (v *Identity) Logout(ctx context.Context, s Session) error {
+	var res response
+	return v.callSession(ctx, "accounts.logout", s, url.Values{
+		"UID":       []string{s.UID},
+		"targetEnv": []string{"mobile"},
+	}, &res)
+}
@@ -0,0 +1,97 @@
+package gigya
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// signForm signs the session authenticated request by adding a
+// timestamp, nonce and signature (sig) to the form. The signature is
+// an HMAC-SHA1 over the request base string keyed by the session
+// secret, this way the secret is never transmitted.
+func signForm(httpMethod, uri string, form url.Values, secret string) error {
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return fmt.Errorf("nonce: %w", err)
+	}
+	form.Set("timestamp", strconv.FormatInt(time.Now().Unix(), 10))
+	form.Set("nonce", hex.EncodeToString(nonce[:]))
+	form.Del("sig")
+
+	sig, err := signature(httpMethod, uri, form, secret)
+	if err != nil {
+		return err
+	}
+	form.Set("sig", sig)
+
+	return nil
+}
+
+// signature returns the base64 encoded HMAC-SHA1 signature of the
+// base string for the request, keyed by the base64 encoded secret.
+func signature(httpMethod, uri string, params url.Values, secret string) (string, error) {
+	key, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return "", fmt.Errorf("decode secret: %w", err)
+	}
+	base, err := baseString(httpMethod, uri, params)
+	if err != nil {
+		return "", err
+	}
+
+	h := hmac.New(sha1.New, key)
+	h.Write([]byte(base))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// baseString returns the OAuth 1.0 style signature base string:
+//
+//	METHOD&percentEncode(normalizedURL)&percentEncode(normalizedParams)
+func baseString(httpMethod, uri string, params url.Values) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", fmt.Errorf("parse url: %w", err)
+	}
+	scheme := strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Hostname())
+	if port := u.Port(); port != "" && !(scheme == "http" && port == "80") && !(scheme == "https" && port == "443") {
+		host += ":" + port
+	}
+	normalizedURL := scheme + "://" + host + u.EscapedPath()
+
+	// Parameters are sorted by encoded name, then by encoded value.
+	type pair struct{ k, v string }
+	var ps []pair
+	for k, vs := range params {
+		for _, v := range vs {
+			ps = append(ps, pair{percentEncode(k), percentEncode(v)})
+		}
+	}
+	sort.Slice(ps, func(i, j int) bool {
+		if ps[i].k != ps[j].k {
+			return ps[i].k < ps[j].k
+		}
+		return ps[i].v < ps[j].v
+	})
+	pairs := make([]string, 0, len(ps))
+	for _, p := range ps {
+		pairs = append(pairs, p.k+"="+p.v)
+	}
+
+	return strings.ToUpper(httpMethod) + "&" + percentEncode(normalizedURL) + "&" + percentEncode(strings.Join(pairs, "&")), nil
+}
+
+// percentEncode encodes s as specified by RFC 3986, only unreserved
+// characters are left as-is.
+func percentEncode(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
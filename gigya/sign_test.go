@@ -0,0 +1,144 @@
+package gigya
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestPercentEncode(t *testing.T) {
+	for _, tt := range []struct {
+		in, want string
+	}{
+		{"abcXYZ019", "abcXYZ019"},
+		{"-._~", "-._~"},
+		{" ", "%20"},
+		{"*", "%2A"},
+		{"+", "%2B"},
+		{"/?&=,", "%2F%3F%26%3D%2C"},
+		{"ä", "%C3%A4"},
+		{"日本", "%E6%97%A5%E6%9C%AC"},
+	} {
+		if got := percentEncode(tt.in); got != tt.want {
+			t.Errorf("percentEncode(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestBaseString_NormalizedURL(t *testing.T) {
+	for _, tt := range []struct {
+		uri, want string
+	}{
+		{"HTTPS://Accounts.EU1.gigya.com:443/accounts.getJWT", "POST&https%3A%2F%2Faccounts.eu1.gigya.com%2Faccounts.getJWT&"},
+		{"http://localhost:80/accounts.getJWT", "POST&http%3A%2F%2Flocalhost%2Faccounts.getJWT&"},
+		{"http://localhost:8080/accounts.getJWT", "POST&http%3A%2F%2Flocalhost%3A8080%2Faccounts.getJWT&"},
+	} {
+		got, err := baseString("post", tt.uri, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("baseString(%q) = %q, want %q", tt.uri, got, tt.want)
+		}
+	}
+}
+
+func TestSignature(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		uri     string
+		params  url.Values
+		secret  string // Base64 encoded.
+		base    string
+		wantSig string
+	}{
+		{
+			// https://developer.twitter.com/en/docs/authentication/oauth-1-0a/creating-a-signature
+			// with the signing key base64 encoded.
+			name: "OAuth 1.0 reference",
+			uri:  "https://api.twitter.com/1.1/statuses/update.json",
+			params: url.Values{
+				"include_entities":       {"true"},
+				"oauth_consumer_key":     {"xvz1evFS4wEEPTGEFPHBog"},
+				"oauth_nonce":            {"kYjzVBB8Y0ZFabxSWbWovY3uYSQ2pTgmZeNu2VS4cg"},
+				"oauth_signature_method": {"HMAC-SHA1"},
+				"oauth_timestamp":        {"1318622958"},
+				"oauth_token":            {"370773112-GmHxMAgYyLbNEtIKZeRNFsMKPR9EyMZeS9weJAEb"},
+				"oauth_version":          {"1.0"},
+				"status":                 {"Hello Ladies + Gentlemen, a signed OAuth request!"},
+			},
+			secret:  "a0FjU09xRjIxRnU4NWU3emp6N1pOMlU0WlJoZlYzV3B3UEFvRTNaN2tCdyZMc3d3ZG9VYUl2UzhsdHlUdDVqa1JoNEo1MHZVUFZWSHRSMllQaTVrRQ==",
+			base:    "POST&https%3A%2F%2Fapi.twitter.com%2F1.1%2Fstatuses%2Fupdate.json&include_entities%3Dtrue%26oauth_consumer_key%3Dxvz1evFS4wEEPTGEFPHBog%26oauth_nonce%3DkYjzVBB8Y0ZFabxSWbWovY3uYSQ2pTgmZeNu2VS4cg%26oauth_signature_method%3DHMAC-SHA1%26oauth_timestamp%3D1318622958%26oauth_token%3D370773112-GmHxMAgYyLbNEtIKZeRNFsMKPR9EyMZeS9weJAEb%26oauth_version%3D1.0%26status%3DHello%2520Ladies%2520%252B%2520Gentlemen%252C%2520a%2520signed%2520OAuth%2520request%2521",
+			wantSig: "hCtSmYh+iHYCEqBWrE7C7hYmtUk=",
+		},
+		{
+			name: "Gigya session request with special characters",
+			uri:  "https://accounts.eu1.gigya.com/accounts.getJWT",
+			params: url.Values{
+				"apikey":          {"3_abc"},
+				"format":          {"json"},
+				"httpStatusCodes": {"false"},
+				"oauth_token":     {"st2.token"},
+				"nonce":           {"0123456789abcdef0123456789abcdef"},
+				"timestamp":       {"1700000000"},
+				"fields":          {"country,name *~"},
+				"targetUID":       {"uid"},
+				"profile":         {"Ääkkönen é 日本"},
+				"a b":             {"x*y~z+1"},
+			},
+			secret:  "c2VjcmV0LWtleQ==",
+			base:    "POST&https%3A%2F%2Faccounts.eu1.gigya.com%2Faccounts.getJWT&a%2520b%3Dx%252Ay~z%252B1%26apikey%3D3_abc%26fields%3Dcountry%252Cname%2520%252A~%26format%3Djson%26httpStatusCodes%3Dfalse%26nonce%3D0123456789abcdef0123456789abcdef%26oauth_token%3Dst2.token%26profile%3D%25C3%2584%25C3%25A4kk%25C3%25B6nen%2520%25C3%25A9%2520%25E6%2597%25A5%25E6%259C%25AC%26targetUID%3Duid%26timestamp%3D1700000000",
+			wantSig: "qFVvwMeBgtzI6kMeutrpcXHUjHU=",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			base, err := baseString("POST", tt.uri, tt.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if base != tt.base {
+				t.Errorf("baseString:\ngot  %s\nwant %s", base, tt.base)
+			}
+
+			sig, err := signature("POST", tt.uri, tt.params, tt.secret)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sig != tt.wantSig {
+				t.Errorf("signature = %q, want %q", sig, tt.wantSig)
+			}
+		})
+	}
+}
+
+func TestSignForm(t *testing.T) {
+	const uri = "https://accounts.eu1.gigya.com/accounts.getJWT"
+	const secret = "c2VjcmV0LWtleQ=="
+
+	form := url.Values{"oauth_token": {"st2.token"}, "sig": {"stale"}}
+	if err := signForm("POST", uri, form, secret); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"timestamp", "nonce", "sig"} {
+		if form.Get(k) == "" {
+			t.Errorf("%s not set", k)
+		}
+	}
+	if form.Has("secret") {
+		t.Error("secret must not be sent")
+	}
+
+	// The signature covers all parameters except sig itself.
+	params := url.Values{}
+	for k, v := range form {
+		if k != "sig" {
+			params[k] = v
+		}
+	}
+	want, err := signature("POST", uri, params, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := form.Get("sig"); got != want {
+		t.Errorf("sig = %q, want %q", got, want)
+	}
+}
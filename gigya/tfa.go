@@ -0,0 +1,175 @@
+package gigya
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// TFAProvider is a two-factor authentication provider.
+type TFAProvider string
+
+const (
+	TFAProviderEmail TFAProvider = "gigyaEmail"
+	TFAProviderPhone TFAProvider = "gigyaPhone"
+)
+
+// TFATarget is an email address or phone number that a verification
+// code can be sent to.
+type TFATarget struct {
+	Provider   TFAProvider
+	ID         string
+	Obfuscated string // Example: "j***@e******.com", "+########89".
+
+	assertion string // From accounts.tfa.initTFA.
+}
+
+// TFAHandler completes two-factor authentication, e.g. by prompting
+// the user.
+type TFAHandler interface {
+	// SelectTarget returns the target to send the verification code
+	// to, targets is never empty.
+	SelectTarget(ctx context.Context, targets []TFATarget) (TFATarget, error)
+	// Code returns the verification code that was sent to target.
+	Code(ctx context.Context, target TFATarget) (string, error)
+}
+
+// tfa performs the two-factor authentication for a login that is
+// pending TFA verification and finalizes the login.
+func (v *Identity) tfa(ctx context.Context, regToken string) (loginResponse, error) {
+	targets, err := v.tfaTargets(ctx, regToken)
+	if err != nil {
+		return loginResponse{}, err
+	}
+	if len(targets) == 0 {
+		return loginResponse{}, errors.New("no verification targets found")
+	}
+
+	target, err := v.config.TFA.SelectTarget(ctx, targets)
+	if err != nil {
+		return loginResponse{}, fmt.Errorf("select target: %w", err)
+	}
+	if target.assertion == "" {
+		return loginResponse{}, errors.New("select target: unknown target")
+	}
+
+	var sent tfaSendCodeResponse
+	form := url.Values{
+		"gigyaAssertion": []string{target.assertion},
+		"lang":           []string{"en"},
+	}
+	switch target.Provider {
+	case TFAProviderEmail:
+		form.Set("emailID", target.ID)
+	case TFAProviderPhone:
+		form.Set("phoneID", target.ID)
+		form.Set("method", "sms")
+	}
+	err = v.call(ctx, tfaMethod(target.Provider, "sendVerificationCode"), form, &sent)
+	if err != nil {
+		return loginResponse{}, err
+	}
+
+	code, err := v.config.TFA.Code(ctx, target)
+	if err != nil {
+		return loginResponse{}, fmt.Errorf("code: %w", err)
+	}
+
+	var verified tfaVerifyResponse
+	err = v.call(ctx, tfaMethod(target.Provider, "completeVerification"), url.Values{
+		"gigyaAssertion": []string{target.assertion},
+		"phvToken":       []string{sent.PhvToken},
+		"code":           []string{code},
+	}, &verified)
+	if err != nil {
+		return loginResponse{}, err
+	}
+
+	var finalized response
+	err = v.call(ctx, "accounts.tfa.finalizeTFA", url.Values{
+		"gigyaAssertion":    []string{target.assertion},
+		"providerAssertion": []string{verified.ProviderAssertion},
+		"regToken":          []string{regToken},
+	}, &finalized)
+	if err != nil {
+		return loginResponse{}, err
+	}
+
+	var res loginResponse
+	err = v.call(ctx, "accounts.finalizeRegistration", url.Values{
+		"regToken":  []string{regToken},
+		"targetEnv": []string{"mobile"},
+	}, &res)
+	if err != nil {
+		return loginResponse{}, err
+	}
+
+	return res, nil
+}
+
+// tfaTargets returns the verification targets of all active TFA
+// providers for the pending login.
+func (v *Identity) tfaTargets(ctx context.Context, regToken string) ([]TFATarget, error) {
+	var providers tfaProvidersResponse
+	err := v.call(ctx, "accounts.tfa.getProviders", url.Values{
+		"regToken": []string{regToken},
+	}, &providers)
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []TFATarget
+	for _, p := range providers.ActiveProviders {
+		provider := TFAProvider(p.Name)
+
+		var list string
+		switch provider {
+		case TFAProviderEmail:
+			list = "getEmails"
+		case TFAProviderPhone:
+			list = "getRegisteredPhoneNumbers"
+		default:
+			continue // Unsupported provider (e.g. TOTP).
+		}
+
+		var init tfaInitResponse
+		err = v.call(ctx, "accounts.tfa.initTFA", url.Values{
+			"provider": []string{p.Name},
+			"mode":     []string{"verify"},
+			"regToken": []string{regToken},
+		}, &init)
+		if err != nil {
+			return nil, err
+		}
+
+		var res tfaTargetsResponse
+		err = v.call(ctx, tfaMethod(provider, list), url.Values{
+			"gigyaAssertion": []string{init.GigyaAssertion},
+		}, &res)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, t := range append(res.Emails, res.Phones...) {
+			targets = append(targets, TFATarget{
+				Provider:   provider,
+				ID:         t.ID,
+				Obfuscated: t.Obfuscated,
+				assertion:  init.GigyaAssertion,
+			})
+		}
+	}
+
+	return targets, nil
+}
+
+// tfaMethod returns the provider specific API method name.
+func tfaMethod(provider TFAProvider, name string) string {
+	switch provider {
+	case TFAProviderPhone:
+		return "accounts.tfa.phone." + name
+	default:
+		return "accounts.tfa.email." + name
+	}
+}
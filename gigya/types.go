@@ -4,6 +4,7 @@ type response struct {
 	CallID       string `json:"callId"`
 	ErrorCode    int    `json:"errorCode"`
 	ErrorMessage string `json:"errorMessage"`
+	ErrorDetails string `json:"errorDetails"`
 	APIVersion   int    `json:"apiVersion"`
 	StatusCode   int    `json:"statusCode"`
 	StatusReason string `json:"statusReason"`
@@ -12,38 +13,15 @@ type response struct {
 
 type loginResponse struct {
 	response
-	RegisteredTimestamp        int         `json:"registeredTimestamp"`
-	Uid                        string      `json:"UID"`
-	UIDSignature               string      `json:"UIDSignature"`
-	SignatureTimestamp         string      `json:"signatureTimestamp"`
-	Created                    string      `json:"created"`
-	CreatedTimestamp           int         `json:"createdTimestamp"`
-	IsActive                   bool        `json:"isActive"`
-	IsRegistered               bool        `json:"isRegistered"`
-	IsVerified                 bool        `json:"isVerified"`
-	LastLogin                  string      `json:"lastLogin"`
-	LastLoginTimestamp         int         `json:"lastLoginTimestamp"`
-	LastUpdated                string      `json:"lastUpdated"`
-	LastUpdatedTimestamp       int         `json:"lastUpdatedTimestamp"`
-	LoginProvider              string      `json:"loginProvider"`
-	OldestDataUpdated          string      `json:"oldestDataUpdated"`
-	OldestDataUpdatedTimestamp int         `json:"oldestDataUpdatedTimestamp"`
-	Profile                    profile     `json:"profile"`
-	Registered                 string      `json:"registered"`
-	SocialProviders            string      `json:"socialProviders"`
-	Verified                   string      `json:"verified"`
-	VerifiedTimestamp          int         `json:"verifiedTimestamp"`
-	NewUser                    bool        `json:"newUser"`
-	SessionInfo                sessionInfo `json:"sessionInfo"`
-}
-
-type profile struct {
-	FirstName string `json:"firstName"`
-	LastName  string `json:"lastName"`
-	City      string `json:"city"`
-	Country   string `json:"country"`
-	Email     string `json:"email"`
-	Zip       string `json:"zip"`
+	Account
+	NewUser     bool        `json:"newUser"`
+	SessionInfo sessionInfo `json:"sessionInfo"`
+	RegToken    string      `json:"regToken"` // Set when login is pending, e.g. TFA.
+}
+
+type accountInfoResponse struct {
+	response
+	Account
 }
 
 type sessionInfo struct {
@@ -56,3 +34,44 @@ type jwtResponse struct {
 	response
 	IDToken string `json:"id_token"`
 }
+
+type tfaProvidersResponse struct {
+	response
+	ActiveProviders   []tfaProvider `json:"activeProviders"`
+	InactiveProviders []tfaProvider `json:"inactiveProviders"`
+}
+
+type tfaProvider struct {
+	Name string `json:"name"`
+}
+
+type tfaInitResponse struct {
+	response
+	GigyaAssertion string `json:"gigyaAssertion"`
+}
+
+type tfaTargetsResponse struct {
+	response
+	Emails []tfaTarget `json:"emails"`
+	Phones []tfaTarget `json:"phones"`
+}
+
+type tfaTarget struct {
+	ID         string `json:"id"`
+	Obfuscated string `json:"obfuscated"`
+}
+
+type tfaSendCodeResponse struct {
+	response
+	PhvToken string `json:"phvToken"`
+}
+
+type tfaVerifyResponse struct {
+	response
+	ProviderAssertion string `json:"providerAssertion"`
+}
+
+type initRegistrationResponse struct {
+	response
+	RegToken string `json:"regToken"`
+}
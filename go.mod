@@ -1,5 +1,12 @@
 module github.com/mafredri/electrolux-ocp
 
-go 1.20
+go 1.21
 
-require golang.org/x/exp v0.0.0-20230817173708-d852ddb80c63
+require (
+	golang.org/x/crypto v0.14.0
+	golang.org/x/exp v0.0.0-20230817173708-d852ddb80c63
+	golang.org/x/oauth2 v0.20.0
+	golang.org/x/time v0.5.0
+)
+
+require golang.org/x/sys v0.13.0 // indirect
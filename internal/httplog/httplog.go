@@ -0,0 +1,297 @@
+// Package httplog implements request logging with secret redaction
+// for the ocpapi and gigya HTTP clients.
+package httplog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Redacted replaces secret values in logs.
+const Redacted = "REDACTED"
+
+// maxBodyLog is the maximum number of body bytes that are logged.
+const maxBodyLog = 64 << 10
+
+// secretKeys are the (lower case) JSON and form keys that are redacted.
+var secretKeys = map[string]bool{
+	"accesstoken":   true,
+	"refreshtoken":  true,
+	"clientsecret":  true,
+	"idtoken":       true,
+	"id_token":      true,
+	"token":         true,
+	"password":      true,
+	"newpassword":   true,
+	"secret":        true,
+	"sessiontoken":  true,
+	"sessionsecret": true,
+	"oauth_token":   true,
+	"regtoken":      true,
+	"sig":           true,
+	"code":          true,
+	"x-api-key":     true,
+}
+
+// piiKeys are the (lower case) JSON and form keys containing personal
+// information, they are redacted by Redactor when PII is set. Strings
+// nested in the values are redacted as well (e.g. "profile").
+var piiKeys = map[string]bool{
+	"email":       true,
+	"emails":      true,
+	"loginid":     true,
+	"uid":         true,
+	"targetuid":   true,
+	"profile":     true,
+	"firstname":   true,
+	"lastname":    true,
+	"nickname":    true,
+	"city":        true,
+	"zip":         true,
+	"address":     true,
+	"phone":       true,
+	"phonenumber": true,
+}
+
+// secretHeaders are the headers that are redacted.
+var secretHeaders = []string{
+	"Authorization",
+	"X-Api-Key",
+	"Cookie",
+	"Set-Cookie",
+}
+
+// requestIDHeaders are the headers checked by RequestID, in order.
+var requestIDHeaders = []string{
+	"X-Request-Id",
+	"X-Correlation-Id",
+	"X-Amzn-Requestid",
+	"X-Amzn-Trace-Id",
+	"Traceparent",
+}
+
+// RequestID returns the request or trace ID from the headers, if any.
+func RequestID(h http.Header) string {
+	for _, k := range requestIDHeaders {
+		if id := h.Get(k); id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+type attemptKey struct{}
+
+// WithAttempt returns a context that records the attempt number of a
+// (retried) request, starting at 1.
+func WithAttempt(ctx context.Context, attempt int) context.Context {
+	return context.WithValue(ctx, attemptKey{}, attempt)
+}
+
+func attempt(ctx context.Context) int {
+	if n, ok := ctx.Value(attemptKey{}).(int); ok {
+		return n
+	}
+	return 1
+}
+
+// Transport is an http.RoundTripper that logs requests and responses.
+// Requests are logged at debug level, failed requests and server
+// errors at warn level.
+type Transport struct {
+	RT     http.RoundTripper
+	Logger *slog.Logger
+	// LogBodies enables logging of (redacted) headers and bodies when
+	// the logger is enabled for debug level.
+	LogBodies bool
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	logBodies := t.LogBodies && t.Logger.Enabled(ctx, slog.LevelDebug)
+
+	attrs := []slog.Attr{
+		slog.String("method", req.Method),
+		slog.String("host", req.URL.Host),
+		slog.String("path", req.URL.Path),
+	}
+	if n := attempt(ctx); n > 1 {
+		attrs = append(attrs, slog.Int("retry", n-1))
+	}
+	if logBodies {
+		attrs = append(attrs, slog.Any("request_header", RedactHeader(req.Header)))
+		if req.GetBody != nil {
+			if body, err := req.GetBody(); err == nil {
+				b, _ := io.ReadAll(io.LimitReader(body, maxBodyLog))
+				body.Close()
+				attrs = append(attrs, slog.String("request_body", RedactBody(req.Header.Get("Content-Type"), b)))
+			}
+		}
+	}
+
+	start := time.Now()
+	resp, err := t.RT.RoundTrip(req)
+	attrs = append(attrs, slog.Duration("duration", time.Since(start)))
+	if err != nil {
+		attrs = append(attrs, slog.Any("error", err))
+		t.Logger.LogAttrs(ctx, slog.LevelWarn, "http request failed", attrs...)
+		return nil, err
+	}
+
+	attrs = append(attrs, slog.Int("status", resp.StatusCode))
+	if id := RequestID(resp.Header); id != "" {
+		attrs = append(attrs, slog.String("request_id", id))
+	}
+	if logBodies {
+		attrs = append(attrs, slog.Any("response_header", RedactHeader(resp.Header)))
+		b, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			// Let the caller see the error when reading the body.
+			resp.Body = io.NopCloser(io.MultiReader(bytes.NewReader(b), errReader{err}))
+		} else {
+			resp.Body = io.NopCloser(bytes.NewReader(b))
+		}
+		if len(b) > maxBodyLog {
+			b = b[:maxBodyLog]
+		}
+		attrs = append(attrs, slog.String("response_body", RedactBody(resp.Header.Get("Content-Type"), b)))
+	}
+
+	level := slog.LevelDebug
+	if resp.StatusCode >= 500 {
+		level = slog.LevelWarn
+	}
+	t.Logger.LogAttrs(ctx, level, "http request", attrs...)
+
+	return resp, nil
+}
+
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
+
+// RedactHeader returns a copy of the header with secrets redacted.
+func RedactHeader(h http.Header) http.Header {
+	h = h.Clone()
+	for _, k := range secretHeaders {
+		if _, ok := h[k]; ok {
+			h.Set(k, Redacted)
+		}
+	}
+	return h
+}
+
+// RedactBody returns the body with secrets redacted, JSON and form
+// encoded bodies are supported. Other bodies are returned as-is.
+func RedactBody(contentType string, b []byte) string {
+	return Redactor{}.Body(contentType, b)
+}
+
+// RedactForm returns the form (or query) encoded values with secrets
+// redacted.
+func RedactForm(s string) string {
+	return Redactor{}.Form(s)
+}
+
+// RedactJSON redacts secrets in the decoded JSON value (in-place).
+func RedactJSON(v any) any {
+	return Redactor{}.JSON(v)
+}
+
+// Redactor redacts secrets and, optionally, personal information.
+type Redactor struct {
+	// PII enables redaction of personal information such as email
+	// addresses, names and user IDs, e.g. for data that is shared.
+	PII bool
+}
+
+func (r Redactor) pii(key string) bool {
+	return r.PII && piiKeys[strings.ToLower(key)]
+}
+
+// Body returns the body redacted, JSON and form encoded bodies are
+// supported. Other bodies are returned as-is.
+func (r Redactor) Body(contentType string, b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		return r.Form(string(b))
+	}
+
+	var v any
+	if err := json.Unmarshal(b, &v); err != nil {
+		return string(b)
+	}
+	out, err := json.Marshal(r.JSON(v))
+	if err != nil {
+		return string(b)
+	}
+	return string(out)
+}
+
+// Form returns the form (or query) encoded values redacted.
+func (r Redactor) Form(s string) string {
+	form, err := url.ParseQuery(s)
+	if err != nil {
+		return s
+	}
+	for k := range form {
+		if secretKeys[strings.ToLower(k)] || r.pii(k) {
+			form[k] = []string{Redacted}
+		}
+	}
+	return form.Encode()
+}
+
+// JSON redacts the decoded JSON value (in-place).
+func (r Redactor) JSON(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, vv := range v {
+			if r.pii(k) {
+				v[k] = redactStrings(vv)
+				continue
+			}
+			if secretKeys[strings.ToLower(k)] {
+				if _, ok := vv.(string); ok {
+					v[k] = Redacted
+					continue
+				}
+			}
+			v[k] = r.JSON(vv)
+		}
+	case []any:
+		for i, vv := range v {
+			v[i] = r.JSON(vv)
+		}
+	}
+	return v
+}
+
+// redactStrings redacts all strings in the decoded JSON value
+// (in-place).
+func redactStrings(v any) any {
+	switch v := v.(type) {
+	case string:
+		return Redacted
+	case map[string]any:
+		for k, vv := range v {
+			v[k] = redactStrings(vv)
+		}
+	case []any:
+		for i, vv := range v {
+			v[i] = redactStrings(vv)
+		}
+	}
+	return v
+}
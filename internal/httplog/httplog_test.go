@@ -0,0 +1,178 @@
+package httplog
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRedactBody(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		contentType string
+		body        string
+		secrets     []string // Must not be in the output.
+		keep        []string // Must be in the output.
+	}{
+		{
+			name:        "token request",
+			contentType: "application/json",
+			body:        `{"grantType": "urn:ietf:params:oauth:grant-type:token-exchange", "clientId": "ElxOneApp", "clientSecret": "client-secret-1", "idToken": "eyJhbGciOi.id-token-1", "scope": ""}`,
+			secrets:     []string{"client-secret-1", "id-token-1"},
+			keep:        []string{"token-exchange", "ElxOneApp"},
+		},
+		{
+			name:        "refresh request",
+			contentType: "application/json",
+			body:        `{"grantType": "refresh_token", "clientId": "ElxOneApp", "refreshToken": "refresh-token-1", "scope": ""}`,
+			secrets:     []string{"refresh-token-1"},
+			keep:        []string{"refresh_token"},
+		},
+		{
+			name:        "token response",
+			contentType: "application/json",
+			body:        `{"accessToken": "access-token-1", "expiresIn": 43200, "tokenType": "Bearer", "refreshToken": "refresh-token-2", "scope": "email offline_access"}`,
+			secrets:     []string{"access-token-1", "refresh-token-2"},
+			keep:        []string{"43200", "Bearer"},
+		},
+		{
+			name:        "revoke request",
+			contentType: "application/json",
+			body:        `{"token": "refresh-token-3", "revokeAll": false}`,
+			secrets:     []string{"refresh-token-3"},
+			keep:        []string{"revokeAll"},
+		},
+		{
+			name:        "gigya login form",
+			contentType: "application/x-www-form-urlencoded",
+			body: url.Values{
+				"apiKey":      {"gigya-api-key"},
+				"loginID":     {"user@example.com"},
+				"password":    {"hunter2"},
+				"oauth_token": {"session-token-1"},
+				"sig":         {"c2lnbmF0dXJl"},
+				"nonce":       {"1700000000000_1"},
+			}.Encode(),
+			secrets: []string{"hunter2", "session-token-1", "c2lnbmF0dXJl"},
+			keep:    []string{"gigya-api-key", "loginID=user%40example.com"},
+		},
+		{
+			name:        "gigya session info",
+			contentType: "application/json",
+			body:        `{"errorCode": 0, "UID": "uid-1", "sessionInfo": {"sessionToken": "st2.session-token-2", "sessionSecret": "session-secret-1"}, "id_token": "eyJhbGciOi.id-token-2"}`,
+			secrets:     []string{"session-token-2", "session-secret-1", "id-token-2"},
+			keep:        []string{"uid-1", "errorCode"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := RedactBody(tt.contentType, []byte(tt.body))
+			for _, s := range tt.secrets {
+				if strings.Contains(got, s) {
+					t.Errorf("output contains %q: %s", s, got)
+				}
+			}
+			for _, s := range tt.keep {
+				if !strings.Contains(got, s) {
+					t.Errorf("output is missing %q: %s", s, got)
+				}
+			}
+			if !strings.Contains(got, Redacted) {
+				t.Errorf("output is not redacted: %s", got)
+			}
+		})
+	}
+}
+
+func TestRedactor_PII(t *testing.T) {
+	body := `{"UID": "uid-1", "profile": {"email": "user@example.com", "firstName": "Firstname"}, "emails": {"verified": ["user@example.com"]}, "sessionInfo": {"sessionToken": "session-token"}}`
+
+	// Personal information is only redacted with PII.
+	if got := RedactBody("application/json", []byte(body)); !strings.Contains(got, "user@example.com") {
+		t.Errorf("RedactBody redacted personal information: %s", got)
+	}
+	got := Redactor{PII: true}.Body("application/json", []byte(body))
+	for _, s := range []string{"uid-1", "user@example.com", "Firstname", "session-token"} {
+		if strings.Contains(got, s) {
+			t.Errorf("output contains %q: %s", s, got)
+		}
+	}
+
+	form := Redactor{PII: true}.Form(url.Values{"loginID": {"user@example.com"}, "targetUID": {"uid-1"}}.Encode())
+	if strings.Contains(form, "example.com") || strings.Contains(form, "uid-1") {
+		t.Errorf("Form = %s", form)
+	}
+}
+
+func TestRedactHeader(t *testing.T) {
+	h := http.Header{}
+	h.Set("Authorization", "Bearer access-token-1")
+	h.Set("X-Api-Key", "api-key-1")
+	h.Set("Cookie", "glt=cookie-1")
+	h.Set("Content-Type", "application/json")
+
+	got := RedactHeader(h)
+	for _, k := range []string{"Authorization", "X-Api-Key", "Cookie"} {
+		if v := got.Get(k); v != Redacted {
+			t.Errorf("%s = %q, want %q", k, v, Redacted)
+		}
+	}
+	if v := got.Get("Content-Type"); v != "application/json" {
+		t.Errorf("Content-Type = %q", v)
+	}
+	if v := h.Get("Authorization"); v != "Bearer access-token-1" {
+		t.Error("RedactHeader modified the original header")
+	}
+}
+
+func TestTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Request-Id", "request-1")
+		fmt.Fprint(w, `{"accessToken": "access-token-2", "refreshToken": "refresh-token-2", "tokenType": "Bearer"}`)
+	}))
+	t.Cleanup(srv.Close)
+
+	var buf bytes.Buffer
+	rt := &Transport{
+		RT:        http.DefaultTransport,
+		Logger:    slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})),
+		LogBodies: true,
+	}
+
+	body := `{"grantType": "refresh_token", "clientId": "ElxOneApp", "refreshToken": "refresh-token-1"}`
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/one-account-authorization/api/v1/token?brand=electrolux&email=user%40example.com", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer access-token-1")
+	req.Header.Set("X-Api-Key", "api-key-1")
+
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var respBody bytes.Buffer
+	_, _ = respBody.ReadFrom(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(respBody.String(), "access-token-2") {
+		t.Errorf("response body not preserved for the caller: %s", respBody.String())
+	}
+
+	out := buf.String()
+	for _, s := range []string{"access-token-1", "access-token-2", "refresh-token-1", "refresh-token-2", "api-key-1", "user@example.com", "user%40example.com", "email="} {
+		if strings.Contains(out, s) {
+			t.Errorf("log contains %q:\n%s", s, out)
+		}
+	}
+	for _, s := range []string{"path=/one-account-authorization/api/v1/token", "status=200", "request_id=request-1", "ElxOneApp"} {
+		if !strings.Contains(out, s) {
+			t.Errorf("log is missing %q:\n%s", s, out)
+		}
+	}
+}
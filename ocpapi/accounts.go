@@ -0,0 +1,178 @@
+package ocpapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// Accounts manages clients for multiple named accounts. The clients
+// share the client credentials token, the HTTP client and rate limits,
+// each client has its own State.
+//
+// Accounts is safe for concurrent use by multiple goroutines.
+type Accounts struct {
+	config Config
+	opts   []Option
+	shared *Client // Owns the client token and HTTP client.
+
+	mu      sync.Mutex
+	names   []string // In the order added.
+	clients map[string]*Client
+}
+
+// AccountConfig is the per-account configuration.
+type AccountConfig struct {
+	State      State      // Optional initial state.
+	StateStore StateStore // Optional, loads the initial state and saves changes.
+}
+
+// NewAccounts returns a new account manager, the config and options
+// are used for all accounts (except State and StateStore, see
+// AccountConfig). Config.UserTokenSource is not supported, since each
+// account needs its own user token.
+func NewAccounts(config Config, opts ...Option) (*Accounts, error) {
+	if config.UserTokenSource != nil {
+		return nil, errors.New("UserTokenSource is not supported with Accounts, each account needs its own user token")
+	}
+	config.State = State{}
+	config.StateStore = nil
+
+	shared, err := New(config, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Accounts{
+		config:  config,
+		opts:    opts,
+		shared:  shared,
+		clients: make(map[string]*Client),
+	}, nil
+}
+
+// Add adds a client for the named account, Login must be called on
+// the client unless the state contains a valid session.
+func (a *Accounts) Add(name string, ac AccountConfig) (*Client, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := a.clients[name]; ok {
+		return nil, fmt.Errorf("account %q already exists", name)
+	}
+
+	config := a.config
+	config.State = ac.State
+	config.StateStore = ac.StateStore
+	config.BackgroundRefresh = false // Started below, once shared.
+	c, err := New(config, a.opts...)
+	if err != nil {
+		return nil, err
+	}
+	c.client = a.shared.client
+	c.transport = a.shared.transport
+	c.limiters = a.shared.limiters
+	c.shared = a.shared
+	if a.config.BackgroundRefresh {
+		c.startKeeper()
+	}
+
+	a.names = append(a.names, name)
+	a.clients[name] = c
+
+	return c, nil
+}
+
+// Remove removes the named account and closes its client. It does not
+// log out, see Client.Logout.
+func (a *Accounts) Remove(name string) error {
+	a.mu.Lock()
+	c, ok := a.clients[name]
+	if ok {
+		delete(a.clients, name)
+		a.names = slices.DeleteFunc(a.names, func(n string) bool { return n == name })
+	}
+	a.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("account %q not found", name)
+	}
+	return c.Close()
+}
+
+// Client returns the client for the named account.
+func (a *Accounts) Client(name string) (*Client, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	c, ok := a.clients[name]
+	return c, ok
+}
+
+// Names returns the names of all accounts in the order they were
+// added.
+func (a *Accounts) Names() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return slices.Clone(a.names)
+}
+
+// AccountAppliance is an appliance and the name of the account it
+// belongs to.
+type AccountAppliance struct {
+	Account string
+	Appliance
+}
+
+// Appliances returns the appliances of all accounts, the accounts are
+// queried concurrently. If some accounts fail, the appliances of the
+// other accounts are returned together with the (joined) errors.
+func (a *Accounts) Appliances(ctx context.Context, includeMetadata bool) ([]AccountAppliance, error) {
+	names := a.Names()
+
+	results := make([][]Appliance, len(names))
+	errs := make([]error, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		c, ok := a.Client(name)
+		if !ok {
+			continue // Removed.
+		}
+		wg.Add(1)
+		go func(i int, name string, c *Client) {
+			defer wg.Done()
+			results[i], errs[i] = c.Appliances(ctx, includeMetadata)
+			if errs[i] != nil {
+				errs[i] = fmt.Errorf("account %q: %w", name, errs[i])
+			}
+		}(i, name, c)
+	}
+	wg.Wait()
+
+	var appliances []AccountAppliance
+	for i, name := range names {
+		for _, ap := range results[i] {
+			appliances = append(appliances, AccountAppliance{Account: name, Appliance: ap})
+		}
+	}
+
+	return appliances, errors.Join(errs...)
+}
+
+// Close closes the clients of all accounts.
+func (a *Accounts) Close() error {
+	a.mu.Lock()
+	clients := append([]*Client{a.shared}, maps.Values(a.clients)...)
+	a.mu.Unlock()
+
+	var errs []error
+	for _, c := range clients {
+		errs = append(errs, c.Close())
+	}
+	return errors.Join(errs...)
+}
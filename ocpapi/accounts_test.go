@@ -0,0 +1,105 @@
+package ocpapi_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/oauth2"
+
+	"github.com/mafredri/electrolux-ocp/ocpapi"
+	"github.com/mafredri/electrolux-ocp/ocptest"
+)
+
+// grantCounter is a transport that counts token requests by grant
+// type.
+type grantCounter struct {
+	mu     sync.Mutex
+	grants map[string]int
+}
+
+func (g *grantCounter) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.HasSuffix(req.URL.Path, "/one-account-authorization/api/v1/token") && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		var tr struct {
+			GrantType string `json:"grantType"`
+		}
+		err = json.NewDecoder(body).Decode(&tr)
+		body.Close()
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		g.mu.Lock()
+		g.grants[tr.GrantType]++
+		g.mu.Unlock()
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func (g *grantCounter) count(grantType string) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.grants[grantType]
+}
+
+func TestAccounts_SharedClientToken(t *testing.T) {
+	s := newTestServer(t)
+	gc := &grantCounter{grants: make(map[string]int)}
+	ctx := context.Background()
+
+	accounts, err := ocpapi.NewAccounts(s.Config(), ocpapi.WithTransport(gc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { accounts.Close() })
+
+	names := []string{"alice", "bob", "carol"}
+	for _, name := range names {
+		c, err := accounts.Add(name, ocpapi.AccountConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = c.LoginWithIDToken(ctx, name+"@example.com", ocptest.IDToken); err != nil {
+			t.Fatalf("LoginWithIDToken %s: %v", name, err)
+		}
+	}
+
+	appliances, err := accounts.Appliances(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(appliances) != len(names) {
+		t.Fatalf("Appliances = %+v, want one per account", appliances)
+	}
+	for i, a := range appliances {
+		if a.Account != names[i] || a.ApplianceID != testApplianceID {
+			t.Errorf("Appliances[%d] = %s %s, want %s %s", i, a.Account, a.ApplianceID, names[i], testApplianceID)
+		}
+	}
+
+	if got := gc.count("client_credentials"); got != 1 {
+		t.Errorf("client_credentials grants = %d, want 1 for %d accounts", got, len(names))
+	}
+	if got := gc.count("urn:ietf:params:oauth:grant-type:token-exchange"); got != len(names) {
+		t.Errorf("token exchange grants = %d, want %d", got, len(names))
+	}
+}
+
+func TestNewAccounts_UserTokenSource(t *testing.T) {
+	s := newTestServer(t)
+	config := s.Config()
+	config.UserTokenSource = oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"})
+
+	if _, err := ocpapi.NewAccounts(config); err == nil || !strings.Contains(err.Error(), "UserTokenSource") {
+		t.Errorf("NewAccounts: got %v, want UserTokenSource error", err)
+	}
+}
@@ -0,0 +1,116 @@
+package ocpapi
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Claims are the claims decoded from the payload of a token's JWT.
+type Claims struct {
+	Subject   string
+	Issuer    string
+	Audience  []string
+	ExpiresAt time.Time // Zero if not present.
+	IssuedAt  time.Time // Zero if not present.
+	Scopes    []string
+	Country   string
+	Brand     string
+
+	// Raw contains all claims as decoded from the payload.
+	Raw map[string]any
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (c *Claims) UnmarshalJSON(b []byte) error {
+	var tmp struct {
+		Subject  string          `json:"sub"`
+		Issuer   string          `json:"iss"`
+		Audience json.RawMessage `json:"aud"` // String or array.
+		Exp      json.Number     `json:"exp"`
+		Iat      json.Number     `json:"iat"`
+		Scope    string          `json:"scope"` // Space-separated.
+		Scp      []string        `json:"scp"`
+		Country  string          `json:"country"`
+		Brand    string          `json:"brand"`
+	}
+	if err := json.Unmarshal(b, &tmp); err != nil {
+		return err
+	}
+	var raw map[string]any
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+
+	*c = Claims{
+		Subject: tmp.Subject,
+		Issuer:  tmp.Issuer,
+		Scopes:  append(strings.Fields(tmp.Scope), tmp.Scp...),
+		Country: tmp.Country,
+		Brand:   tmp.Brand,
+		Raw:     raw,
+	}
+	if len(tmp.Audience) > 0 {
+		var aud string
+		if err := json.Unmarshal(tmp.Audience, &aud); err == nil {
+			c.Audience = []string{aud}
+		} else if err = json.Unmarshal(tmp.Audience, &c.Audience); err != nil {
+			return fmt.Errorf("aud: %w", err)
+		}
+	}
+	var err error
+	if c.ExpiresAt, err = numericDate(tmp.Exp); err != nil {
+		return fmt.Errorf("exp: %w", err)
+	}
+	if c.IssuedAt, err = numericDate(tmp.Iat); err != nil {
+		return fmt.Errorf("iat: %w", err)
+	}
+
+	return nil
+}
+
+// numericDate converts a JWT NumericDate (seconds since epoch) to time.
+func numericDate(n json.Number) (time.Time, error) {
+	if n == "" {
+		return time.Time{}, nil
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, int64(f*float64(time.Second))), nil
+}
+
+// Claims decodes the claims of the access token (JWT). The signature
+// is not verified.
+func (t Token) Claims() (Claims, error) {
+	parts := strings.Split(t.AccessToken, ".")
+	if len(parts) != 3 {
+		return Claims{}, errors.New("access token is not a JWT")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return Claims{}, fmt.Errorf("decode payload: %w", err)
+	}
+
+	var c Claims
+	err = json.Unmarshal(payload, &c)
+	if err != nil {
+		return Claims{}, fmt.Errorf("unmarshal payload: %w", err)
+	}
+
+	return c, nil
+}
+
+// Expiry returns the expiry time of the token. The exp claim of the
+// access token is preferred, ExpiresAt is used if it is not present.
+func (t Token) Expiry() time.Time {
+	if c, err := t.Claims(); err == nil && !c.ExpiresAt.IsZero() {
+		return c.ExpiresAt
+	}
+	return t.ExpiresAt
+}
@@ -0,0 +1,192 @@
+package ocpapi_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/mafredri/electrolux-ocp/ocpapi"
+	"github.com/mafredri/electrolux-ocp/ocptest"
+)
+
+const testApplianceID = ocpapi.ApplianceID("950011538111111115087076")
+
+// memStateStore is a StateStore that records all saved states.
+type memStateStore struct {
+	mu    sync.Mutex
+	saved []ocpapi.State
+}
+
+func (s *memStateStore) Load() (ocpapi.State, error) { return ocpapi.State{}, nil }
+
+func (s *memStateStore) Save(state ocpapi.State) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.saved = append(s.saved, state)
+	return nil
+}
+
+func (s *memStateStore) refreshTokens() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var tokens []string
+	for _, state := range s.saved {
+		if rt := state.UserToken.RefreshToken; rt != "" {
+			tokens = append(tokens, rt)
+		}
+	}
+	return tokens
+}
+
+func newTestServer(t *testing.T) *ocptest.Server {
+	s := ocptest.NewServer()
+	t.Cleanup(s.Close)
+
+	var a ocpapi.Appliance
+	a.ApplianceID = testApplianceID
+	a.Properties.Reported.Workmode = "Auto"
+	s.AddAppliance(a, ocpapi.ApplianceInfo{PNC: testApplianceID.PNC(), Model: "PUREA9"})
+	return s
+}
+
+func newLoggedInClient(t *testing.T, s *ocptest.Server, config ocpapi.Config) *ocpapi.Client {
+	c, err := ocpapi.New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.LoginWithIDToken(context.Background(), "user@example.com", ocptest.IDToken); err != nil {
+		t.Fatalf("LoginWithIDToken: %v", err)
+	}
+	return c
+}
+
+func TestClient_LoginWithIDToken(t *testing.T) {
+	s := newTestServer(t)
+	c := newLoggedInClient(t, s, s.Config())
+
+	state := c.State()
+	if state.RegionalBaseURL != s.URL {
+		t.Errorf("RegionalBaseURL = %q, want %q", state.RegionalBaseURL, s.URL)
+	}
+	if state.UserToken.RefreshToken == "" {
+		t.Error("missing refresh token")
+	}
+	claims, err := state.UserToken.Claims()
+	if err != nil {
+		t.Fatalf("Claims: %v", err)
+	}
+	if claims.Country != ocptest.CountryCode || !state.UserToken.Expiry().Equal(claims.ExpiresAt) {
+		t.Errorf("claims = %+v, expiry %v", claims, state.UserToken.Expiry())
+	}
+
+	c, err = ocpapi.New(s.Config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.LoginWithIDToken(context.Background(), "user@example.com", "invalid")
+	if !ocpapi.IsUnauthorized(err) {
+		t.Errorf("LoginWithIDToken with invalid ID token: got %v, want unauthorized", err)
+	}
+}
+
+func TestClient_RefreshAndRetryOnUnauthorized(t *testing.T) {
+	s := newTestServer(t)
+	c := newLoggedInClient(t, s, s.Config())
+	ctx := context.Background()
+	old := c.State().UserToken
+
+	// The tokens are still valid according to the client.
+	s.ExpireTokens()
+	if _, err := c.Appliances(ctx, false); err != nil {
+		t.Fatalf("Appliances: %v", err)
+	}
+
+	rotated := c.State().UserToken
+	if rotated.AccessToken == old.AccessToken || rotated.RefreshToken == old.RefreshToken {
+		t.Fatal("token was not refreshed")
+	}
+
+	// The refresh token was rotated, the old one is no longer valid.
+	c2 := newLoggedInClient(t, s, s.Config())
+	if err := c2.ResumeWithRefreshToken(ctx, old.RefreshToken); !ocpapi.IsUnauthorized(err) {
+		t.Errorf("ResumeWithRefreshToken with rotated token: got %v, want unauthorized", err)
+	}
+	if err := c2.ResumeWithRefreshToken(ctx, rotated.RefreshToken); err != nil {
+		t.Errorf("ResumeWithRefreshToken: %v", err)
+	}
+}
+
+func TestClient_RevokedRefreshToken(t *testing.T) {
+	s := newTestServer(t)
+	c := newLoggedInClient(t, s, s.Config())
+
+	s.RevokeRefreshTokens()
+	s.ExpireTokens()
+	_, err := c.Appliances(context.Background(), false)
+	if !ocpapi.IsUnauthorized(err) {
+		t.Errorf("Appliances: got %v, want unauthorized", err)
+	}
+}
+
+func TestClient_LogoutAfterExpiry(t *testing.T) {
+	s := newTestServer(t)
+	store := &memStateStore{}
+	config := s.Config()
+	config.StateStore = store
+	c := newLoggedInClient(t, s, config)
+	ctx := context.Background()
+
+	s.ExpireTokens()
+	if err := c.Logout(ctx); err != nil {
+		t.Fatalf("Logout: %v", err)
+	}
+	if c.State() != (ocpapi.State{}) {
+		t.Error("state not cleared")
+	}
+
+	c2 := newLoggedInClient(t, s, s.Config())
+	for _, rt := range store.refreshTokens() {
+		if err := c2.ResumeWithRefreshToken(ctx, rt); err == nil {
+			t.Errorf("refresh token %q still valid after Logout", rt)
+		}
+	}
+}
+
+func TestClient_Appliances(t *testing.T) {
+	s := newTestServer(t)
+	c := newLoggedInClient(t, s, s.Config())
+	ctx := context.Background()
+
+	appliances, err := c.Appliances(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(appliances) != 1 || appliances[0].ApplianceID != testApplianceID || appliances[0].Properties.Reported.Workmode != "Auto" {
+		t.Fatalf("Appliances = %+v", appliances)
+	}
+
+	err = s.UpdateReported(testApplianceID, func(r *ocpapi.Reported) {
+		r.Workmode = "Manual"
+		r.Fanspeed = 3
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	appliances, err = c.Appliances(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := appliances[0].Properties.Reported; r.Workmode != "Manual" || r.Fanspeed != 3 {
+		t.Errorf("reported = %+v, want updated state", r)
+	}
+
+	info, err := c.AppliancesInfo(ctx, testApplianceID.String(), "unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info) != 1 || info[0].Model != "PUREA9" {
+		t.Errorf("AppliancesInfo = %+v", info)
+	}
+}
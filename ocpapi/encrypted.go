@@ -0,0 +1,290 @@
+package ocpapi
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Encrypted state format (version 1):
+//
+//	magic   [8]byte  "OCPSTATE"
+//	version uint8    1
+//	time    uint32   Argon2id iterations.
+//	memory  uint32   Argon2id memory in KiB.
+//	threads uint8    Argon2id parallelism.
+//	salt    [16]byte
+//	nonce   [24]byte XChaCha20-Poly1305 nonce.
+//	data    []byte   Encrypted JSON state, the header is authenticated.
+const (
+	encryptedStateMagic   = "OCPSTATE"
+	encryptedStateVersion = 1
+
+	encryptedStateHeaderLen = len(encryptedStateMagic) + 1 + 4 + 4 + 1 + encryptedStateSaltLen + chacha20poly1305.NonceSizeX
+	encryptedStateSaltLen   = 16
+
+	// Argon2id parameters as recommended by RFC 9106 (second choice).
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+
+	// argon2MaxTime and argon2MaxMemory (in KiB) limit the time and
+	// memory used to decrypt corrupted or crafted files.
+	argon2MaxTime   = 100
+	argon2MaxMemory = 1024 * 1024
+)
+
+// ErrDecryptState is returned when the state can not be decrypted,
+// e.g. due to a wrong passphrase.
+var ErrDecryptState = errors.New("decrypt state: wrong passphrase or corrupted data")
+
+// ErrPlainState is returned by EncryptedFileStateStore.Load for a
+// state file that is not encrypted, see MigrateStateFile.
+var ErrPlainState = errors.New("state file is not encrypted")
+
+// EncryptState encrypts the state with a key derived from passphrase.
+func EncryptState(state State, passphrase []byte) ([]byte, error) {
+	k, err := newStateKey(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return k.encrypt(state)
+}
+
+// DecryptState decrypts state encrypted by EncryptState. For migration
+// purposes, plain JSON state is accepted as well.
+func DecryptState(data []byte, passphrase []byte) (State, error) {
+	if !IsEncryptedState(data) {
+		if b := bytes.TrimSpace(data); len(b) > 0 && b[0] == '{' {
+			var state State
+			if err := json.Unmarshal(b, &state); err != nil {
+				return State{}, fmt.Errorf("unmarshal plain state: %w", err)
+			}
+			return state, nil
+		}
+		return State{}, errors.New("decrypt state: unknown format")
+	}
+
+	state, _, err := decryptState(data, passphrase)
+	return state, err
+}
+
+// stateKey is a key derived from a passphrase, together with the
+// parameters used to derive it. Deriving is slow by design, so the key
+// can be reused for multiple encryptions (each uses a random nonce).
+type stateKey struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+	salt    []byte
+	aead    cipher.AEAD
+}
+
+// newStateKey derives a key from passphrase using a random salt.
+func newStateKey(passphrase []byte) (*stateKey, error) {
+	salt := make([]byte, encryptedStateSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("random: %w", err)
+	}
+	return deriveStateKey(passphrase, salt, argon2Time, argon2Memory, argon2Threads)
+}
+
+func deriveStateKey(passphrase, salt []byte, time, memory uint32, threads uint8) (*stateKey, error) {
+	aead, err := chacha20poly1305.NewX(argon2.IDKey(passphrase, salt, time, memory, threads, chacha20poly1305.KeySize))
+	if err != nil {
+		return nil, err
+	}
+	return &stateKey{time: time, memory: memory, threads: threads, salt: salt, aead: aead}, nil
+}
+
+// encrypt encrypts the state using a random nonce.
+func (k *stateKey) encrypt(state State) ([]byte, error) {
+	plaintext, err := json.Marshal(state)
+	if err != nil {
+		return nil, fmt.Errorf("marshal: %w", err)
+	}
+
+	header := make([]byte, 0, encryptedStateHeaderLen)
+	header = append(header, encryptedStateMagic...)
+	header = append(header, encryptedStateVersion)
+	header = binary.BigEndian.AppendUint32(header, k.time)
+	header = binary.BigEndian.AppendUint32(header, k.memory)
+	header = append(header, k.threads)
+	header = append(header, k.salt...)
+	nonce := header[len(header):encryptedStateHeaderLen]
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("random: %w", err)
+	}
+	header = header[:encryptedStateHeaderLen]
+
+	return k.aead.Seal(header, nonce, plaintext, header), nil
+}
+
+// decryptState decrypts the encrypted state and returns the derived
+// key for reuse.
+func decryptState(data []byte, passphrase []byte) (State, *stateKey, error) {
+	if len(data) < encryptedStateHeaderLen {
+		return State{}, nil, ErrDecryptState
+	}
+
+	header := data[:encryptedStateHeaderLen]
+	p := header[len(encryptedStateMagic):]
+	if version := p[0]; version != encryptedStateVersion {
+		return State{}, nil, fmt.Errorf("decrypt state: unsupported version %d", version)
+	}
+	t := binary.BigEndian.Uint32(p[1:5])
+	m := binary.BigEndian.Uint32(p[5:9])
+	threads := p[9]
+	salt := bytes.Clone(p[10 : 10+encryptedStateSaltLen])
+	nonce := p[10+encryptedStateSaltLen:]
+	if t < 1 || t > argon2MaxTime || threads < 1 || m > argon2MaxMemory {
+		return State{}, nil, fmt.Errorf("%w: invalid key derivation parameters (time %d, memory %d KiB, threads %d)", ErrDecryptState, t, m, threads)
+	}
+
+	k, err := deriveStateKey(passphrase, salt, t, m, threads)
+	if err != nil {
+		return State{}, nil, err
+	}
+	plaintext, err := k.aead.Open(nil, nonce, data[encryptedStateHeaderLen:], header)
+	if err != nil {
+		return State{}, nil, ErrDecryptState
+	}
+
+	var state State
+	err = json.Unmarshal(plaintext, &state)
+	if err != nil {
+		return State{}, nil, fmt.Errorf("unmarshal: %w", err)
+	}
+
+	return state, k, nil
+}
+
+// IsEncryptedState reports whether data is in the encrypted state
+// format.
+func IsEncryptedState(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(encryptedStateMagic))
+}
+
+// ReadEncryptedStateFile reads and decrypts the state file at path,
+// plain JSON state files are read as-is.
+func ReadEncryptedStateFile(path string, passphrase []byte) (State, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return State{}, err
+	}
+	return DecryptState(b, passphrase)
+}
+
+// WriteEncryptedStateFile encrypts and atomically writes the state to
+// the file at path.
+func WriteEncryptedStateFile(path string, passphrase []byte, state State) error {
+	b, err := EncryptState(state, passphrase)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, b, 0o600)
+}
+
+// MigrateStateFile encrypts a plain JSON state file in place. It is a
+// no-op if the file is already encrypted.
+func MigrateStateFile(path string, passphrase []byte) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if IsEncryptedState(b) {
+		return nil
+	}
+
+	state, err := DecryptState(b, passphrase)
+	if err != nil {
+		return err
+	}
+	return WriteEncryptedStateFile(path, passphrase, state)
+}
+
+// EncryptedFileStateStore is a StateStore that keeps the state
+// encrypted in a file. Plain JSON state files (e.g. written by
+// FileStateStore) are rejected with ErrPlainState, since they are not
+// authenticated, unless AllowPlainState is set. Use MigrateStateFile
+// to encrypt them instead.
+//
+// The key is derived once (or when loading an encrypted file) and
+// reused for later saves, so that saving is fast.
+type EncryptedFileStateStore struct {
+	// AllowPlainState loads plain JSON state files as-is, they are
+	// encrypted on the next save. Only for migrating trusted files.
+	AllowPlainState bool
+
+	path       string
+	passphrase []byte
+
+	mu  sync.Mutex
+	key *stateKey // Derived on first use.
+}
+
+var _ StateStore = (*EncryptedFileStateStore)(nil)
+
+// NewEncryptedFileStateStore returns a StateStore backed by the file
+// at path, encrypted with a key derived from passphrase.
+func NewEncryptedFileStateStore(path string, passphrase []byte) *EncryptedFileStateStore {
+	return &EncryptedFileStateStore{path: path, passphrase: passphrase}
+}
+
+// Load implements StateStore.
+func (s *EncryptedFileStateStore) Load() (State, error) {
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return State{}, nil
+		}
+		return State{}, err
+	}
+	if !IsEncryptedState(b) {
+		if !s.AllowPlainState {
+			return State{}, fmt.Errorf("load %s: %w", s.path, ErrPlainState)
+		}
+		return DecryptState(b, s.passphrase)
+	}
+
+	state, k, err := decryptState(b, s.passphrase)
+	if err != nil {
+		return State{}, err
+	}
+
+	s.mu.Lock()
+	s.key = k
+	s.mu.Unlock()
+
+	return state, nil
+}
+
+// Save implements StateStore.
+func (s *EncryptedFileStateStore) Save(state State) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.key == nil {
+		k, err := newStateKey(s.passphrase)
+		if err != nil {
+			return err
+		}
+		s.key = k
+	}
+
+	b, err := s.key.encrypt(state)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, b, 0o600)
+}
@@ -0,0 +1,138 @@
+package ocpapi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+func TestEncryptState(t *testing.T) {
+	want := State{RegionalBaseURL: "https://api.eu.ocp.electrolux.one", UserToken: Token{RefreshToken: "refresh"}}
+
+	b, err := EncryptState(want, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncryptedState(b) {
+		t.Fatal("IsEncryptedState = false, want true")
+	}
+
+	got, err := DecryptState(b, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.RegionalBaseURL != want.RegionalBaseURL || got.UserToken.RefreshToken != want.UserToken.RefreshToken {
+		t.Errorf("DecryptState = %+v, want %+v", got, want)
+	}
+
+	if _, err = DecryptState(b, []byte("wrong")); !errors.Is(err, ErrDecryptState) {
+		t.Errorf("DecryptState with wrong passphrase: got %v, want ErrDecryptState", err)
+	}
+}
+
+func TestDecryptState_InvalidParameters(t *testing.T) {
+	header := func(time, memory uint32, threads uint8) []byte {
+		b := []byte(encryptedStateMagic)
+		b = append(b, encryptedStateVersion)
+		b = binary.BigEndian.AppendUint32(b, time)
+		b = binary.BigEndian.AppendUint32(b, memory)
+		b = append(b, threads)
+		return append(b, make([]byte, 64)...) // Salt, nonce and data.
+	}
+
+	for _, tt := range []struct {
+		name string
+		data []byte
+	}{
+		{"zeros", append([]byte(encryptedStateMagic+"\x01"), make([]byte, 64)...)},
+		{"zero time", header(0, argon2Memory, argon2Threads)},
+		{"zero threads", header(argon2Time, argon2Memory, 0)},
+		{"huge time", header(1<<32-1, argon2Memory, argon2Threads)},
+		{"huge memory", header(argon2Time, 1<<32-1, argon2Threads)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecryptState(tt.data, []byte("passphrase"))
+			if !errors.Is(err, ErrDecryptState) {
+				t.Errorf("DecryptState: got %v, want ErrDecryptState", err)
+			}
+		})
+	}
+}
+
+func TestEncryptedFileStateStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state")
+	passphrase := []byte("passphrase")
+
+	s := NewEncryptedFileStateStore(path, passphrase)
+	for _, rt := range []string{"refresh-1", "refresh-2"} {
+		if err := s.Save(State{UserToken: Token{RefreshToken: rt}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b1, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A new store reuses the key (and salt) of the loaded file.
+	s = NewEncryptedFileStateStore(path, passphrase)
+	state, err := s.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := state.UserToken.RefreshToken, "refresh-2"; got != want {
+		t.Errorf("refresh token = %q, want %q", got, want)
+	}
+	if err = s.Save(state); err != nil {
+		t.Fatal(err)
+	}
+	b2, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saltEnd := encryptedStateHeaderLen - chacha20poly1305.NonceSizeX
+	if !bytes.Equal(b1[:saltEnd], b2[:saltEnd]) {
+		t.Error("key derivation parameters or salt changed between saves")
+	}
+	if bytes.Equal(b1[saltEnd:encryptedStateHeaderLen], b2[saltEnd:encryptedStateHeaderLen]) {
+		t.Error("nonce reused between saves")
+	}
+	if _, err = DecryptState(b2, passphrase); err != nil {
+		t.Errorf("DecryptState: %v", err)
+	}
+}
+
+func TestEncryptedFileStateStore_PlainState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state")
+	passphrase := []byte("passphrase")
+	if err := os.WriteFile(path, []byte(`{"regionalBaseUrl": "https://attacker.example.com"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewEncryptedFileStateStore(path, passphrase)
+	if _, err := s.Load(); !errors.Is(err, ErrPlainState) {
+		t.Fatalf("Load: got %v, want ErrPlainState", err)
+	}
+
+	s.AllowPlainState = true
+	state, err := s.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.RegionalBaseURL == "" {
+		t.Error("plain state not loaded")
+	}
+
+	if err = MigrateStateFile(path, passphrase); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewEncryptedFileStateStore(path, passphrase).Load(); err != nil {
+		t.Errorf("Load after MigrateStateFile: %v", err)
+	}
+}
@@ -0,0 +1,121 @@
+package ocpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"golang.org/x/exp/slices"
+
+	"github.com/mafredri/electrolux-ocp/internal/httplog"
+)
+
+// APIError is returned for unsuccessful (non-2xx) responses from the
+// OCP API, use errors.As to access it.
+type APIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Header     http.Header
+	Body       []byte // Raw response body.
+
+	// Response is the parsed error response, nil if the body is not
+	// an OCP error response.
+	Response *ErrorResponse
+}
+
+// ErrorResponse is the error response body returned by the OCP API.
+type ErrorResponse struct {
+	Code    string `json:"error"` // Example: "UNAUTHORIZED".
+	Message string `json:"message"`
+	Detail  string `json:"detail"`
+}
+
+func newAPIError(resp *http.Response) *APIError {
+	b, _ := io.ReadAll(resp.Body)
+	e := &APIError{
+		Method:     resp.Request.Method,
+		Path:       resp.Request.URL.Path,
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       b,
+	}
+
+	var er ErrorResponse
+	if err := json.Unmarshal(b, &er); err == nil && er != (ErrorResponse{}) {
+		e.Response = &er
+	}
+
+	return e
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("unexpected status code for %s %q: %d", e.Method, e.Path, e.StatusCode)
+	if e.Response != nil {
+		msg += fmt.Sprintf(", error: %s: %s", e.Response.Code, e.Response.Message)
+		if e.Response.Detail != "" {
+			msg += ": " + e.Response.Detail
+		}
+	} else {
+		msg += fmt.Sprintf(", body: %s", string(e.Body))
+	}
+	if id := e.RequestID(); id != "" {
+		msg += fmt.Sprintf(" (request id: %s)", id)
+	}
+	return msg
+}
+
+// RequestID returns the request or trace ID of the response, if any.
+func (e *APIError) RequestID() string {
+	return httplog.RequestID(e.Header)
+}
+
+// tokenErrorCodes are the error codes of a 403 response that mean the
+// access token was rejected, other 403 responses are permission errors.
+var tokenErrorCodes = []string{"UNAUTHORIZED", "INVALID_TOKEN", "TOKEN_EXPIRED"}
+
+// tokenRejected reports whether the server rejected the access token.
+func (e *APIError) tokenRejected() bool {
+	switch e.StatusCode {
+	case http.StatusUnauthorized:
+		return true
+	case http.StatusForbidden:
+		return e.Response != nil && slices.Contains(tokenErrorCodes, strings.ToUpper(e.Response.Code))
+	}
+	return false
+}
+
+// grantRejected reports whether the server rejected the grant of a
+// token request, e.g. a revoked or expired refresh token, as opposed
+// to a rate limit or server error.
+func (e *APIError) grantRejected() bool {
+	return e.StatusCode == http.StatusBadRequest || e.StatusCode == http.StatusUnauthorized
+}
+
+// IsNotFound reports whether err is an *APIError with status 404.
+func IsNotFound(err error) bool {
+	return hasStatusCode(err, http.StatusNotFound)
+}
+
+// IsUnauthorized reports whether err is an *APIError with status 401.
+func IsUnauthorized(err error) bool {
+	return hasStatusCode(err, http.StatusUnauthorized)
+}
+
+// IsForbidden reports whether err is an *APIError with status 403.
+func IsForbidden(err error) bool {
+	return hasStatusCode(err, http.StatusForbidden)
+}
+
+// IsRateLimited reports whether err is an *APIError with status 429.
+func IsRateLimited(err error) bool {
+	return hasStatusCode(err, http.StatusTooManyRequests)
+}
+
+func hasStatusCode(err error, code int) bool {
+	var e *APIError
+	return errors.As(err, &e) && e.StatusCode == code
+}
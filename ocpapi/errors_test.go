@@ -0,0 +1,30 @@
+package ocpapi
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAPIError_TokenRejected(t *testing.T) {
+	for _, tt := range []struct {
+		status int
+		code   string
+		want   bool
+	}{
+		{http.StatusUnauthorized, "", true},
+		{http.StatusUnauthorized, "UNAUTHORIZED", true},
+		{http.StatusForbidden, "UNAUTHORIZED", true},
+		{http.StatusForbidden, "invalid_token", true},
+		{http.StatusForbidden, "FORBIDDEN", false},
+		{http.StatusForbidden, "", false},
+		{http.StatusBadRequest, "INVALID_TOKEN", false},
+	} {
+		e := &APIError{StatusCode: tt.status}
+		if tt.code != "" {
+			e.Response = &ErrorResponse{Code: tt.code}
+		}
+		if got := e.tokenRejected(); got != tt.want {
+			t.Errorf("tokenRejected(%d, %q) = %t, want %t", tt.status, tt.code, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package ocpapi
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"time"
+)
+
+const (
+	keeperRefreshAhead = 5 * time.Minute  // Refresh tokens this long before expiry (plus jitter).
+	keeperMaxWait      = time.Minute      // Re-check the state at least this often.
+	keeperMinBackoff   = 5 * time.Second  // Initial wait after a failed refresh.
+	keeperMaxBackoff   = 10 * time.Minute // Maximum wait after failed refreshes.
+)
+
+// keeper renews tokens in the background, see Config.BackgroundRefresh.
+type keeper struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func (c *Client) startKeeper() {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.keeper = &keeper{cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(c.keeper.done)
+		c.keepTokens(ctx)
+	}()
+}
+
+// Close stops the background token refresher, if started. The client
+// can still be used after Close, but tokens are only refreshed on
+// demand.
+func (c *Client) Close() error {
+	if c.keeper != nil {
+		c.keeper.cancel()
+		<-c.keeper.done
+	}
+	return nil
+}
+
+func (c *Client) keepTokens(ctx context.Context) {
+	backoff := keeperMinBackoff
+	for {
+		wait := keeperMaxWait
+		err := c.refreshDueTokens(ctx, &wait)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			wait = jitter(backoff)
+			backoff = min(backoff*2, keeperMaxBackoff)
+
+			c.log(ctx, slog.LevelWarn, "background token refresh failed", "error", err, "retry_in", wait)
+			if c.config.OnAuthError != nil {
+				c.config.OnAuthError(err)
+			}
+		} else {
+			backoff = keeperMinBackoff
+		}
+
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// refreshDueTokens refreshes the tokens that are about to expire and
+// lowers wait to the time until the next token is due.
+func (c *Client) refreshDueTokens(ctx context.Context, wait *time.Duration) error {
+	state := c.State()
+	now := c.now()
+
+	for _, t := range []struct {
+		name    string
+		token   Token
+		refresh func(context.Context, string) (Token, error)
+	}{
+		{"client token", state.ClientToken, c.clientToken},
+		{"user token", state.UserToken, c.userToken},
+	} {
+		if t.token.AccessToken == "" {
+			continue
+		}
+		due := t.token.Expiry().Add(-keeperRefreshAhead - c.config.ExpirySkew - jitter(keeperRefreshAhead/2))
+		if d := due.Sub(now); d > 0 {
+			*wait = min(*wait, d)
+			continue
+		}
+
+		// Passing the current token as rejected forces a refresh,
+		// unless it was already refreshed by someone else.
+		_, err := t.refresh(ctx, t.token.AccessToken)
+		if err != nil {
+			return fmt.Errorf("background refresh: %s: %w", t.name, err)
+		}
+	}
+
+	return nil
+}
+
+// jitter returns a random duration in [d/2, d).
+func jitter(d time.Duration) time.Duration {
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
@@ -0,0 +1,141 @@
+package ocpapi_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mafredri/electrolux-ocp/ocpapi"
+	"github.com/mafredri/electrolux-ocp/ocptest"
+)
+
+// clock is a settable clock shared by the client and the server.
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *clock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+// tokenLifetime is short enough for the keeper to refresh tokens once
+// the clock has been advanced by tokenDue, and long enough for them to
+// not be due before that.
+const (
+	tokenLifetime = 10 * time.Minute
+	tokenDue      = 8 * time.Minute
+)
+
+// newKeeperServer returns a server and the state of a user logged in
+// at the current time of clk.
+func newKeeperServer(t *testing.T, clk *clock) (*ocptest.Server, ocpapi.State) {
+	s := ocptest.NewServer(ocptest.WithClock(clk.Now), ocptest.WithTokenLifetime(tokenLifetime))
+	t.Cleanup(s.Close)
+
+	c, err := ocpapi.New(s.Config(), ocpapi.WithClock(clk.Now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.LoginWithIDToken(context.Background(), "user@example.com", ocptest.IDToken); err != nil {
+		t.Fatal(err)
+	}
+	return s, c.State()
+}
+
+func newKeeperClient(t *testing.T, s *ocptest.Server, clk *clock, state ocpapi.State, onAuthError func(error)) *ocpapi.Client {
+	config := s.Config()
+	config.State = state
+	config.BackgroundRefresh = true
+	config.OnAuthError = onAuthError
+	c, err := ocpapi.New(config, ocpapi.WithClock(clk.Now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestKeeper_RefreshAhead(t *testing.T) {
+	clk := &clock{now: time.Now()}
+	s, state := newKeeperServer(t, clk)
+
+	// Not due yet.
+	c := newKeeperClient(t, s, clk, state, nil)
+	time.Sleep(100 * time.Millisecond)
+	if got := c.State().UserToken; got.AccessToken != state.UserToken.AccessToken {
+		t.Fatal("token refreshed before it was due")
+	}
+	c.Close()
+
+	// Due, but still valid.
+	clk.Add(tokenDue)
+	c = newKeeperClient(t, s, clk, state, func(err error) { t.Errorf("OnAuthError: %v", err) })
+	deadline := time.Now().Add(5 * time.Second)
+	for c.State().UserToken.AccessToken == state.UserToken.AccessToken {
+		if time.Now().After(deadline) {
+			t.Fatal("token was not refreshed ahead of expiry")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	token := c.State().UserToken
+	if token.RefreshToken == state.UserToken.RefreshToken {
+		t.Error("refresh token was not rotated")
+	}
+	if !token.Expiry().After(state.UserToken.Expiry()) {
+		t.Errorf("expiry = %v, want after %v", token.Expiry(), state.UserToken.Expiry())
+	}
+}
+
+func TestKeeper_FailureBackoffAndClose(t *testing.T) {
+	clk := &clock{now: time.Now()}
+	s, state := newKeeperServer(t, clk)
+	s.RevokeRefreshTokens()
+	clk.Add(tokenDue)
+
+	errc := make(chan error, 10)
+	c := newKeeperClient(t, s, clk, state, func(err error) { errc <- err })
+
+	select {
+	case err := <-errc:
+		if !ocpapi.IsUnauthorized(err) {
+			t.Errorf("OnAuthError: got %v, want unauthorized", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnAuthError was not called")
+	}
+
+	// The next attempt waits for the backoff (at least half of the
+	// minimum backoff), instead of retrying in a tight loop.
+	select {
+	case err := <-errc:
+		t.Fatalf("OnAuthError called again without backoff: %v", err)
+	case <-time.After(time.Second):
+	}
+
+	// Close stops the goroutine waiting for the backoff.
+	closed := make(chan struct{})
+	go func() {
+		c.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close did not stop the background refresher")
+	}
+	if len(errc) != 0 {
+		t.Errorf("OnAuthError called %d more times", len(errc))
+	}
+}
@@ -0,0 +1,66 @@
+package ocpapi
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// OAuth2 converts the token to an oauth2.Token.
+func (t Token) OAuth2() *oauth2.Token {
+	return &oauth2.Token{
+		AccessToken:  t.AccessToken,
+		TokenType:    t.TokenType,
+		RefreshToken: t.RefreshToken,
+		Expiry:       t.Expiry(),
+	}
+}
+
+// TokenFromOAuth2 converts an oauth2.Token to a Token.
+func TokenFromOAuth2(t *oauth2.Token) Token {
+	token := Token{
+		AccessToken:  t.AccessToken,
+		TokenType:    t.Type(),
+		RefreshToken: t.RefreshToken,
+		ExpiresAt:    t.Expiry,
+	}
+	if !t.Expiry.IsZero() {
+		token.ExpiresIn = int(time.Until(t.Expiry).Seconds())
+	}
+	if scope, ok := t.Extra("scope").(string); ok {
+		token.Scope = scope
+	}
+	return token
+}
+
+// ClientTokenSource returns a token source for the client credentials
+// token, tokens are requested and renewed by the client as needed.
+func (c *Client) ClientTokenSource() oauth2.TokenSource {
+	return tokenSource(c.clientToken)
+}
+
+// UserTokenSource returns a token source for the user token, the token
+// is refreshed by the client as needed. Login must be called first.
+func (c *Client) UserTokenSource() oauth2.TokenSource {
+	return tokenSource(c.userToken)
+}
+
+type tokenSource func(ctx context.Context, rejected string) (Token, error)
+
+func (fn tokenSource) Token() (*oauth2.Token, error) {
+	t, err := fn(context.Background(), "")
+	if err != nil {
+		return nil, err
+	}
+	return t.OAuth2(), nil
+}
+
+// externalToken returns a token from an externally supplied source.
+func externalToken(ts oauth2.TokenSource) (Token, error) {
+	t, err := ts.Token()
+	if err != nil {
+		return Token{}, err
+	}
+	return TokenFromOAuth2(t), nil
+}
@@ -0,0 +1,57 @@
+package ocpapi
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestNew_UserTokenSourceRequiresRegionalBaseURL(t *testing.T) {
+	config := Config{
+		APIKey:          "api-key",
+		Brand:           "electrolux",
+		ClientID:        "client-id",
+		ClientSecret:    "client-secret",
+		CountryCode:     "FI",
+		UserTokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "access"}),
+	}
+
+	_, err := New(config)
+	if err == nil || !strings.Contains(err.Error(), "RegionalBaseURL") {
+		t.Errorf("New without regional base URL: got %v, want error", err)
+	}
+
+	config.State.RegionalBaseURL = "https://api.eu.ocp.electrolux.one"
+	if _, err = New(config); err != nil {
+		t.Errorf("New: %v", err)
+	}
+}
+
+func TestClient_UserTokenSourceRejectedTokenNotRetried(t *testing.T) {
+	s := newFakeTokenServer(t)
+	rt := &countingRoundTripper{rt: http.DefaultTransport}
+	c, err := New(Config{
+		APIURL:          s.URL,
+		APIKey:          "api-key",
+		Brand:           "electrolux",
+		ClientID:        "client-id",
+		ClientSecret:    "client-secret",
+		CountryCode:     "FI",
+		State:           State{RegionalBaseURL: s.URL},
+		UserTokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "invalid", TokenType: "Bearer"}),
+	}, WithTransport(rt))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.Appliances(context.Background(), false)
+	if !IsUnauthorized(err) {
+		t.Errorf("Appliances: got %v, want unauthorized", err)
+	}
+	if got := rt.n.Load(); got != 1 {
+		t.Errorf("requests = %d, want 1 (the unchanged token must not be retried)", got)
+	}
+}
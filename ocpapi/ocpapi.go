@@ -7,31 +7,60 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/mafredri/electrolux-ocp/gigya"
+	"github.com/mafredri/electrolux-ocp/internal/httplog"
 	"golang.org/x/exp/slices"
+	"golang.org/x/oauth2"
 )
 
 const (
 	APIURL = "https://api.ocp.electrolux.one"
+
+	// DefaultExpirySkew is the default margin before a token's
+	// expiry at which it is considered expired and is refreshed.
+	DefaultExpirySkew = 30 * time.Second
 )
 
 // State contains the current state of the client
 // (e.g. for saving and restoring auth tokens).
 type State struct {
-	RegionalBaseURL string `json:"regionalBaseUrl"`
-	ClientToken     Token  `json:"clientToken"`
-	UserToken       Token  `json:"userToken"`
+	RegionalBaseURL  string            `json:"regionalBaseUrl"`
+	IdentityProvider *IdentityProvider `json:"identityProvider,omitempty"` // Set by Login.
+	ClientToken      Token             `json:"clientToken"`
+	UserToken        Token             `json:"userToken"`
+	GigyaSession     *gigya.Session    `json:"gigyaSession,omitempty"` // Set by Login when Config.KeepGigyaSession is set.
 }
 
 // Client is an Electrolux OCP API client.
+//
+// A Client is safe for concurrent use by multiple goroutines. Token
+// refreshes are coordinated so that only one refresh is in flight at a
+// time, concurrent callers wait for its result.
 type Client struct {
-	config Config
-	client *http.Client
-
-	state State
+	config    Config
+	client    *http.Client
+	transport http.RoundTripper // Base transport, without OCP headers.
+	shared    *Client           // Provides the client token, if set (see Accounts).
+	limiters  rateLimiters
+	logger    *slog.Logger // Nil if logging is disabled.
+	logBodies bool
+	now       func() time.Time
+
+	mu          sync.Mutex // Protects the fields below.
+	state       State
+	clientFetch *tokenFlight // In-flight client token request.
+	userRefresh *tokenFlight // In-flight user token refresh.
+	logouts     uint64       // Incremented by Logout, flights started before do not write back.
+
+	saveMu sync.Mutex // Serializes StateStore saves, see saveState.
+
+	keeper *keeper // Background token refresher, if enabled.
 }
 
 type Config struct {
@@ -42,10 +71,75 @@ type Config struct {
 	ClientSecret string
 	CountryCode  string // Example: "FI"
 
-	State State // Optional initial state.
+	State      State      // Optional initial state.
+	StateStore StateStore // Optional, loads the initial state and saves changes.
+
+	// GigyaBaseURL overrides the Gigya accounts API URL derived from
+	// the identity provider domain, e.g. for testing. Optional.
+	GigyaBaseURL string
+	// TFA completes two-factor authentication during Login, e.g. by
+	// prompting for the verification code. Optional.
+	TFA gigya.TFAHandler
+	// KeepGigyaSession keeps the Gigya session in State after Login.
+	// When the refresh token is lost or rejected, the client mints a
+	// new ID token from the session and exchanges it for a user token,
+	// without the password. The session must be kept as secret as the
+	// password.
+	KeepGigyaSession bool
+
+	// DataCenter is the preferred data center (e.g. "EU") when the
+	// account has multiple identity providers. Optional.
+	DataCenter string
+	// SelectIdentityProvider selects the identity provider to use
+	// when the account has more than one. Optional, by default the
+	// providers are filtered by DataCenter (if set), providers for
+	// Brand are preferred and ties are broken by data center, brand
+	// and domain order.
+	SelectIdentityProvider func([]IdentityProvider) (IdentityProvider, error)
+
+	// ExpirySkew is the margin before expiry at which tokens are
+	// refreshed, to account for clock skew and request latency.
+	// Defaults to DefaultExpirySkew.
+	ExpirySkew time.Duration
+
+	// ClientTokenSource and UserTokenSource supply the client
+	// credentials and user tokens externally, instead of requesting
+	// and refreshing them via the client. The tokens are not stored
+	// in State. Optional.
+	//
+	// With UserTokenSource, Login is not needed but the regional base
+	// URL must be known from State (or StateStore), see
+	// IdentityProvider.HTTPRegionalBaseURL.
+	//
+	// When the server rejects a token, the source is asked for a token
+	// again and the request is only retried if it returns a different
+	// one. Sources that cache tokens must handle rejection themselves.
+	ClientTokenSource oauth2.TokenSource
+	UserTokenSource   oauth2.TokenSource
+
+	// Retry is the retry policy for transient errors, it can be
+	// overridden per call via WithRetryPolicy. Optional, defaults to
+	// DefaultRetryPolicy.
+	Retry *RetryPolicy
+
+	// RateLimits are client-side rate limits per endpoint family,
+	// requests wait until they are allowed. Optional, no limits by
+	// default (see DefaultRateLimits).
+	RateLimits *RateLimits
+
+	// BackgroundRefresh renews the user and client tokens in the
+	// background ahead of their expiry, so that requests do not have
+	// to wait for a refresh. Close must be called to stop it.
+	BackgroundRefresh bool
+	// OnAuthError is called when a background token refresh fails,
+	// or when the state cannot be saved after a token refresh (the
+	// refresh itself succeeds). Optional.
+	OnAuthError func(error)
 }
 
-func New(config Config) (*Client, error) {
+// New returns a new client for the config, options can be used to
+// customize e.g. the HTTP client.
+func New(config Config, opts ...Option) (*Client, error) {
 	if config.APIURL == "" {
 		config.APIURL = APIURL
 	}
@@ -64,25 +158,110 @@ func New(config Config) (*Client, error) {
 	if config.CountryCode == "" {
 		return nil, errors.New("missing CountryCode")
 	}
-
-	httpClient := &http.Client{
-		Timeout: 30 * time.Second,
+	if config.ExpirySkew == 0 {
+		config.ExpirySkew = DefaultExpirySkew
 	}
+
+	o := newOptions(opts)
+	httpClient, transport := o.httpClientAndTransport()
 	c := &Client{
-		client: httpClient,
-		config: config,
-		state:  config.State,
+		client:    httpClient,
+		transport: transport,
+		logger:    o.logger,
+		logBodies: o.logBodies,
+		now:       o.now,
+		config:    config,
+		state:     config.State,
+	}
+	retry := DefaultRetryPolicy
+	if config.Retry != nil {
+		retry = *config.Retry
+	}
+	if config.RateLimits != nil {
+		c.limiters = newRateLimiters(*config.RateLimits)
+	}
+	rt := c.transport
+	if c.logger != nil {
+		rt = &httplog.Transport{RT: rt, Logger: c.logger, LogBodies: c.logBodies}
+	}
+	httpClient.Transport = &clientTransport{
+		rt:        newRetryTransport(newRateLimitTransport(rt, c.limiters), retry),
+		apiKey:    config.APIKey,
+		userAgent: o.userAgent,
+		language:  o.language,
+	}
+
+	if config.StateStore != nil {
+		state, err := config.StateStore.Load()
+		if err != nil {
+			return nil, fmt.Errorf("load state: %w", err)
+		}
+		if state != (State{}) {
+			c.state = state
+		}
+	}
+	if config.UserTokenSource != nil && c.state.RegionalBaseURL == "" {
+		return nil, errors.New("missing State.RegionalBaseURL, required with UserTokenSource")
+	}
+
+	if config.BackgroundRefresh {
+		c.startKeeper()
 	}
-	httpClient.Transport = newClientTransport(http.DefaultTransport, config.APIKey)
 
 	return c, nil
 }
 
 // State returns the current state of the client.
 func (c *Client) State() State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	return c.state
 }
 
+// log logs the message if logging is enabled (see WithLogger).
+func (c *Client) log(ctx context.Context, level slog.Level, msg string, args ...any) {
+	if c.logger != nil {
+		c.logger.Log(ctx, level, msg, args...)
+	}
+}
+
+func (c *Client) regionalBaseURL() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.state.RegionalBaseURL
+}
+
+// saveState persists the current state if a StateStore is configured.
+// It must be called without c.mu held. Saves are serialized and always
+// write the latest state, so a slow save cannot overwrite a newer one.
+func (c *Client) saveState() error {
+	if c.config.StateStore == nil {
+		return nil
+	}
+
+	c.saveMu.Lock()
+	defer c.saveMu.Unlock()
+
+	if err := c.config.StateStore.Save(c.State()); err != nil {
+		return fmt.Errorf("save state: %w", err)
+	}
+	return nil
+}
+
+// saveRefreshedState saves the state after a token refresh. Errors
+// are reported (see Config.OnAuthError) instead of returned, the
+// refreshed token is valid and in use regardless.
+func (c *Client) saveRefreshedState(ctx context.Context) {
+	if err := c.saveState(); err != nil {
+		c.log(ctx, slog.LevelError, "failed to save refreshed state", "error", err)
+		if c.config.OnAuthError != nil {
+			c.config.OnAuthError(err)
+		}
+	}
+}
+
 type IdentityProvider struct {
 	Domain                   string `json:"domain"` // "eu1.gigya.com"
 	APIKey                   string `json:"apiKey"`
@@ -108,6 +287,52 @@ func (c *Client) IdentityProviders(ctx context.Context, email string) ([]Identit
 	return ips, nil
 }
 
+// selectIdentityProvider selects one of the identity providers, see
+// Config.SelectIdentityProvider.
+func (c *Client) selectIdentityProvider(ips []IdentityProvider) (IdentityProvider, error) {
+	switch {
+	case len(ips) == 0:
+		return IdentityProvider{}, errors.New("no identity providers found")
+	case len(ips) == 1:
+		return ips[0], nil
+	case c.config.SelectIdentityProvider != nil:
+		return c.config.SelectIdentityProvider(ips)
+	}
+
+	if dc := c.config.DataCenter; dc != "" {
+		var found []IdentityProvider
+		for _, ip := range ips {
+			if strings.EqualFold(ip.DataCenter, dc) {
+				found = append(found, ip)
+			}
+		}
+		if len(found) == 0 {
+			return IdentityProvider{}, fmt.Errorf("no identity provider found for data center %q", dc)
+		}
+		ips = found
+	}
+
+	ips = slices.Clone(ips)
+	slices.SortFunc(ips, func(a, b IdentityProvider) int {
+		// Prefer the configured brand.
+		if ab, bb := a.Brand == c.config.Brand, b.Brand == c.config.Brand; ab != bb {
+			if ab {
+				return -1
+			}
+			return 1
+		}
+		if n := strings.Compare(a.DataCenter, b.DataCenter); n != 0 {
+			return n
+		}
+		if n := strings.Compare(a.Brand, b.Brand); n != 0 {
+			return n
+		}
+		return strings.Compare(a.Domain, b.Domain)
+	})
+
+	return ips[0], nil
+}
+
 type Country struct {
 	Name           string `json:"name"`
 	CountryCode    string `json:"countryCode"`
@@ -124,7 +349,7 @@ func countryCodes(cs ...Country) (codes []string) {
 }
 
 func (c *Client) Countries(ctx context.Context) ([]Country, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/one-account-user/api/v1/countries", c.state.RegionalBaseURL), nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/one-account-user/api/v1/countries", c.regionalBaseURL()), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -139,61 +364,251 @@ func (c *Client) Countries(ctx context.Context) ([]Country, error) {
 }
 
 // Login logs in to the API using the provided email and password.
+//
+// If State contains a Gigya session (see Config.KeepGigyaSession), it
+// is used instead of the password, which may then be left empty.
+//
+// Login returns early without validating the state if it already
+// contains a refresh token, use Validate to check that the session
+// is still alive.
 func (c *Client) Login(ctx context.Context, email, password string) error {
-	if c.state.RegionalBaseURL != "" && c.state.UserToken.RefreshToken != "" {
+	state := c.State()
+
+	if state.RegionalBaseURL != "" && state.UserToken.RefreshToken != "" {
 		// Assume a valid base URL and token has been provided.
 		return nil
 	}
 
-	ips, err := c.IdentityProviders(ctx, email)
+	ip, err := c.identityProvider(ctx, email)
 	if err != nil {
-		return fmt.Errorf("identity providers: %w", err)
-	}
-
-	switch len(ips) {
-	case 0:
-		return fmt.Errorf("no identity providers found")
-	case 1:
-	default:
-		return fmt.Errorf("multiple identity providers found, only one is supported: found %d providers", len(ips))
+		return err
 	}
-	ip := ips[0]
-	c.state.RegionalBaseURL = ip.HTTPRegionalBaseURL
 
-	if c.state.UserToken.RefreshToken != "" {
+	if state.UserToken.RefreshToken != "" {
 		// Assume a valid token has been provided.
 		return nil
 	}
 
-	countries, err := c.Countries(ctx)
+	err = c.checkCountry(ctx)
 	if err != nil {
-		return fmt.Errorf("countries: %w", err)
+		return err
 	}
 
-	if codes := countryCodes(countries...); !slices.Contains(codes, c.config.CountryCode) {
-		return fmt.Errorf("country code %q not found in available countries: %v", c.config.CountryCode, codes)
+	gi := c.gigyaIdentity(ip)
+
+	var idToken string
+	session := state.GigyaSession
+	if session != nil && !session.Expired() {
+		idToken, err = gi.JWT(ctx, *session)
+		if err != nil && password == "" {
+			return fmt.Errorf("gigya session: %w", err)
+		}
+	}
+	if idToken == "" {
+		s, _, err := gi.LoginSession(ctx, email, password)
+		if err != nil {
+			return fmt.Errorf("gigya login: %w", err)
+		}
+		session = &s
+
+		idToken, err = gi.JWT(ctx, s)
+		if err != nil {
+			return fmt.Errorf("gigya login: %w", err)
+		}
+	}
+
+	userToken, err := c.tokenExchange(ctx, idToken)
+	if err != nil {
+		return fmt.Errorf("auth token: %w", err)
+	}
+
+	c.mu.Lock()
+	c.state.UserToken = userToken
+	if c.config.KeepGigyaSession {
+		c.state.GigyaSession = session
+	}
+	c.mu.Unlock()
+
+	return c.saveState()
+}
+
+// LoginWithIDToken logs in to the API using a Gigya ID token (JWT)
+// obtained elsewhere, e.g. via gigya.Identity. The email is used to
+// look up the identity provider.
+func (c *Client) LoginWithIDToken(ctx context.Context, email, idToken string) error {
+	_, err := c.identityProvider(ctx, email)
+	if err != nil {
+		return err
+	}
+
+	err = c.checkCountry(ctx)
+	if err != nil {
+		return err
 	}
 
-	gi := gigya.NewIdentity(gigya.Config{
+	userToken, err := c.tokenExchange(ctx, idToken)
+	if err != nil {
+		return fmt.Errorf("auth token: %w", err)
+	}
+
+	c.mu.Lock()
+	c.state.UserToken = userToken
+	c.mu.Unlock()
+
+	return c.saveState()
+}
+
+// ResumeWithRefreshToken resumes a session using the refresh token.
+// The token is refreshed immediately, so an error is returned if the
+// refresh token is no longer valid. The regional base URL must be
+// known from State, e.g. from a previous Login.
+func (c *Client) ResumeWithRefreshToken(ctx context.Context, refreshToken string) error {
+	if c.regionalBaseURL() == "" {
+		return errors.New("regional base URL is unknown, please login first")
+	}
+
+	userToken, err := c.refreshToken(ctx, Token{RefreshToken: refreshToken})
+	if err != nil {
+		return fmt.Errorf("refresh token: %w", err)
+	}
+
+	c.mu.Lock()
+	c.state.UserToken = userToken
+	c.mu.Unlock()
+
+	return c.saveState()
+}
+
+// Validate checks that the session is alive by refreshing the user
+// token, an error is returned if it is not.
+func (c *Client) Validate(ctx context.Context) error {
+	state := c.State()
+	if state.RegionalBaseURL == "" {
+		return errors.New("regional base URL is unknown, please login first")
+	}
+
+	_, err := c.userToken(ctx, state.UserToken.AccessToken)
+	return err
+}
+
+// gigyaIdentity returns the Gigya identity for the identity provider.
+// It shares the transport (but not the OCP headers) of the client.
+func (c *Client) gigyaIdentity(ip IdentityProvider) *gigya.Identity {
+	return gigya.NewIdentity(gigya.Config{
 		Domain: ip.Domain,
 		APIKey: ip.APIKey,
+		HTTPClient: &http.Client{
+			Transport: c.transport,
+			Timeout:   c.client.Timeout,
+		},
+		BaseURL:   c.config.GigyaBaseURL,
+		TFA:       c.config.TFA,
+		Logger:    c.logger,
+		LogBodies: c.logBodies,
 	})
-	idToken, err := gi.Login(ctx, email, password)
+}
+
+// identityProvider looks up and selects the identity provider for the
+// email and records it in the state.
+func (c *Client) identityProvider(ctx context.Context, email string) (IdentityProvider, error) {
+	ips, err := c.IdentityProviders(ctx, email)
 	if err != nil {
-		return fmt.Errorf("gigya login: %w", err)
+		return IdentityProvider{}, fmt.Errorf("identity providers: %w", err)
 	}
 
-	c.state.UserToken, err = c.tokenExchange(ctx, idToken)
+	ip, err := c.selectIdentityProvider(ips)
 	if err != nil {
-		return fmt.Errorf("auth token: %w", err)
+		return IdentityProvider{}, fmt.Errorf("select identity provider: %w", err)
+	}
+
+	c.mu.Lock()
+	changed := c.state.IdentityProvider == nil || *c.state.IdentityProvider != ip
+	if changed {
+		c.state.RegionalBaseURL = ip.HTTPRegionalBaseURL
+		c.state.IdentityProvider = &ip
+	}
+	c.mu.Unlock()
+
+	if changed {
+		if err = c.saveState(); err != nil {
+			return IdentityProvider{}, err
+		}
+	}
+
+	return ip, nil
+}
+
+// checkCountry checks that the configured country code is available.
+func (c *Client) checkCountry(ctx context.Context) error {
+	countries, err := c.Countries(ctx)
+	if err != nil {
+		return fmt.Errorf("countries: %w", err)
+	}
+
+	if codes := countryCodes(countries...); !slices.Contains(codes, c.config.CountryCode) {
+		return fmt.Errorf("country code %q not found in available countries: %v", c.config.CountryCode, codes)
 	}
 
 	return nil
 }
 
+// Logout ends the session by revoking the user refresh token and
+// logging out of the Gigya session (if known). The state is cleared
+// even if revoking fails, in which case the errors are returned. Token
+// requests still in flight when the state is cleared are discarded.
+func (c *Client) Logout(ctx context.Context) error {
+	// Let an in-flight refresh finish first, so that the refresh
+	// token it rotates to is the one revoked below.
+	c.waitFlights(ctx)
+	state := c.State()
+
+	var errs []error
+	if state.UserToken.RefreshToken != "" && state.RegionalBaseURL != "" {
+		if err := c.revokeToken(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("revoke token: %w", err))
+		}
+	}
+	if state.GigyaSession != nil && state.IdentityProvider != nil {
+		gi := c.gigyaIdentity(*state.IdentityProvider)
+		if err := gi.Logout(ctx, *state.GigyaSession); err != nil {
+			errs = append(errs, fmt.Errorf("gigya logout: %w", err))
+		}
+	}
+
+	c.mu.Lock()
+	c.state = State{}
+	c.logouts++
+	c.mu.Unlock()
+
+	if err := c.saveState(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// waitFlights waits for the in-flight token requests, if any, or until
+// ctx is done.
+func (c *Client) waitFlights(ctx context.Context) {
+	c.mu.Lock()
+	flights := []*tokenFlight{c.clientFetch, c.userRefresh}
+	c.mu.Unlock()
+
+	for _, tf := range flights {
+		if tf == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-tf.done:
+		}
+	}
+}
+
 // Appliances contains data from all appliances.
 func (c *Client) Appliances(ctx context.Context, includeMetadata bool) ([]Appliance, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/appliance/api/v2/appliances?includeMetadata=%t", c.state.RegionalBaseURL, includeMetadata), nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/appliance/api/v2/appliances?includeMetadata=%t", c.regionalBaseURL(), includeMetadata), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -213,7 +628,7 @@ func (c *Client) AppliancesInfo(ctx context.Context, applianceIDs ...string) ([]
 	if err != nil {
 		return nil, fmt.Errorf("marshal: %w", err)
 	}
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/appliance/api/v2/appliances/info", c.state.RegionalBaseURL), bytes.NewReader(body))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/appliance/api/v2/appliances/info", c.regionalBaseURL()), bytes.NewReader(body))
 	if err != nil {
 		return nil, err
 	}
@@ -232,7 +647,7 @@ func (c *Client) AppliancesInfo(ctx context.Context, applianceIDs ...string) ([]
 type Token struct {
 	AccessToken  string    `json:"accessToken"`            // JWT.
 	ExpiresIn    int       `json:"expiresIn"`              // Seconds.
-	ExpiresAt    time.Time `json:"expiresAt"`              // Set by UnmarshalJSON (now + expires in).
+	ExpiresAt    time.Time `json:"expiresAt"`              // Set by UnmarshalJSON (exp claim or now + expires in).
 	TokenType    string    `json:"tokenType"`              // "Bearer"
 	RefreshToken string    `json:"refreshToken,omitempty"` // Set for login.
 	Scope        string    `json:"scope"`                  // Example: "", "email offline_access eluxiot:*:*:*"
@@ -243,7 +658,8 @@ func (t Token) Authorization() string {
 	return fmt.Sprintf("%s %s", t.TokenType, t.AccessToken)
 }
 
-// UnmarshalJSON implements json.Unmarshaler and assigns ExpiresAt.
+// UnmarshalJSON implements json.Unmarshaler and assigns ExpiresAt if
+// it is not set.
 func (t *Token) UnmarshalJSON(b []byte) error {
 	now := time.Now()
 
@@ -254,11 +670,24 @@ func (t *Token) UnmarshalJSON(b []byte) error {
 	}
 	*t = Token(tmp)
 	if t.ExpiresAt.IsZero() {
-		t.ExpiresAt = now.Add(time.Duration(t.ExpiresIn) * time.Second)
+		if c, err := t.Claims(); err == nil && !c.ExpiresAt.IsZero() {
+			t.ExpiresAt = c.ExpiresAt
+		} else {
+			t.ExpiresAt = now.Add(time.Duration(t.ExpiresIn) * time.Second)
+		}
 	}
 	return nil
 }
 
+// stampExpiry sets ExpiresAt relative to the client clock at the time
+// the token was requested, unless the token has an exp claim.
+func (c *Client) stampExpiry(now time.Time, t Token) Token {
+	if claims, err := t.Claims(); err != nil || claims.ExpiresAt.IsZero() {
+		t.ExpiresAt = now.Add(time.Duration(t.ExpiresIn) * time.Second)
+	}
+	return t
+}
+
 type tokenRequest struct {
 	GrantType    string `json:"grantType"`
 	ClientID     string `json:"clientId"`
@@ -285,13 +714,14 @@ func (c *Client) clientCredentials(ctx context.Context) (Token, error) {
 	}
 	req.Header.Add("Content-Type", "application/json")
 
+	now := c.now()
 	var t Token
 	err = c.do(ctx, req, &t)
 	if err != nil {
 		return Token{}, fmt.Errorf("do: %w", err)
 	}
 
-	return t, nil
+	return c.stampExpiry(now, t), nil
 }
 
 func (c *Client) tokenExchange(ctx context.Context, idToken string) (Token, error) {
@@ -316,13 +746,53 @@ func (c *Client) refreshToken(ctx context.Context, token Token) (Token, error) {
 	})
 }
 
+// revokeToken revokes the current user refresh token. The request
+// is not made via doUserAuth because refreshing the user token (when
+// it has expired or is rejected) rotates the refresh token, instead
+// the request is built for the refreshed token on retry.
+func (c *Client) revokeToken(ctx context.Context) error {
+	var rejected string
+	for retry := false; ; retry = true {
+		token, err := c.userToken(ctx, rejected)
+		if err != nil {
+			return err
+		}
+		if token.RefreshToken == "" {
+			return nil
+		}
+
+		body, err := json.Marshal(map[string]any{
+			"token":     token.RefreshToken,
+			"revokeAll": false,
+		})
+		if err != nil {
+			return fmt.Errorf("marshal: %w", err)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/one-account-authorization/api/v1/token/revoke", c.regionalBaseURL()), bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Add("Content-Type", "application/json")
+		req.Header.Set("Authorization", token.Authorization())
+
+		err = c.do(ctx, req, nil)
+		var apiErr *APIError
+		if !retry && errors.As(err, &apiErr) && apiErr.tokenRejected() {
+			rejected = token.AccessToken
+			continue
+		}
+		return err
+	}
+}
+
 func (c *Client) token(ctx context.Context, tr tokenRequest) (Token, error) {
 	body, err := json.Marshal(tr)
 	if err != nil {
 		return Token{}, fmt.Errorf("marshal: %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/one-account-authorization/api/v1/token", c.state.RegionalBaseURL), bytes.NewReader(body))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/one-account-authorization/api/v1/token", c.regionalBaseURL()), bytes.NewReader(body))
 	if err != nil {
 		return Token{}, err
 	}
@@ -330,44 +800,265 @@ func (c *Client) token(ctx context.Context, tr tokenRequest) (Token, error) {
 	req.Header.Add("Content-Type", "application/json")
 	req.Header.Set("Origin-Country-Code", c.config.CountryCode)
 
+	now := c.now()
 	var t Token
 	err = c.do(ctx, req, &t)
 	if err != nil {
 		return Token{}, fmt.Errorf("do: %w", err)
 	}
 
-	return t, nil
+	return c.stampExpiry(now, t), nil
 }
 
 func (c *Client) doClientAuth(ctx context.Context, req *http.Request, v any) error {
-	if c.state.ClientToken.AccessToken == "" || time.Now().After(c.state.ClientToken.ExpiresAt) {
-		var err error
-		c.state.ClientToken, err = c.clientCredentials(ctx)
+	return c.doAuth(ctx, req, v, c.clientToken)
+}
+
+func (c *Client) doUserAuth(ctx context.Context, req *http.Request, v any) error {
+	return c.doAuth(ctx, req, v, c.userToken)
+}
+
+// doAuth performs the request authorized by the token returned by
+// tokenFn. If the token is rejected by the server, tokenFn is asked
+// to refresh it and the request is retried once, unless tokenFn
+// returns the rejected token again (e.g. an external token source).
+func (c *Client) doAuth(ctx context.Context, req *http.Request, v any, tokenFn func(ctx context.Context, rejected string) (Token, error)) error {
+	err := bufferBody(req)
+	if err != nil {
+		return fmt.Errorf("buffer body: %w", err)
+	}
+
+	var rejected string
+	var rejectedErr error
+	for retry := false; ; retry = true {
+		token, err := tokenFn(ctx, rejected)
 		if err != nil {
-			return fmt.Errorf("client token: %w", err)
+			return err
+		}
+		if retry && token.AccessToken == rejected {
+			return rejectedErr
+		}
+
+		r := req
+		if retry {
+			r = req.Clone(ctx)
+			if req.GetBody != nil {
+				r.Body, err = req.GetBody()
+				if err != nil {
+					return fmt.Errorf("get body: %w", err)
+				}
+			}
+		}
+		r.Header.Set("Authorization", token.Authorization())
+
+		err = c.do(ctx, r, v)
+		var apiErr *APIError
+		if !retry && errors.As(err, &apiErr) && apiErr.tokenRejected() {
+			rejected, rejectedErr = token.AccessToken, err
+			continue
 		}
+		return err
 	}
+}
 
-	req.Header.Add("Authorization", c.state.ClientToken.Authorization())
+// bufferBody makes sure the request body can be replayed via GetBody.
+func bufferBody(req *http.Request) error {
+	if req.Body == nil || req.Body == http.NoBody || req.GetBody != nil {
+		return nil
+	}
 
-	return c.do(ctx, req, v)
+	b, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return err
+	}
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(b)), nil
+	}
+	req.Body, _ = req.GetBody()
+
+	return nil
 }
 
-func (c *Client) doUserAuth(ctx context.Context, req *http.Request, v any) error {
-	if c.state.UserToken.AccessToken == "" {
-		return errors.New("please login before using this endpoint")
+// expired reports whether the token is expired or about to expire.
+func (c *Client) expired(t Token) bool {
+	return !c.now().Add(c.config.ExpirySkew).Before(t.Expiry())
+}
+
+// clientToken returns a valid client token, requesting a new one if
+// the current token is missing, expired or was rejected by the server.
+func (c *Client) clientToken(ctx context.Context, rejected string) (Token, error) {
+	if c.config.ClientTokenSource != nil {
+		token, err := externalToken(c.config.ClientTokenSource)
+		if err != nil {
+			return Token{}, fmt.Errorf("client token: %w", err)
+		}
+		return token, nil
+	}
+	if c.shared != nil {
+		return c.shared.clientToken(ctx, rejected)
+	}
+
+	c.mu.Lock()
+	token := c.state.ClientToken
+	if token.AccessToken != "" && token.AccessToken != rejected && !c.expired(token) {
+		c.mu.Unlock()
+		return token, nil
+	}
+
+	logouts := c.logouts
+	token, err := c.flight(ctx, &c.clientFetch, func(ctx context.Context) (Token, error) {
+		token, err := c.clientCredentials(ctx)
+		if err != nil {
+			return Token{}, err
+		}
+		c.log(ctx, slog.LevelDebug, "requested client token", "expires_at", token.Expiry())
+
+		c.mu.Lock()
+		if c.logouts != logouts {
+			c.mu.Unlock()
+			return Token{}, errLoggedOut
+		}
+		c.state.ClientToken = token
+		c.mu.Unlock()
+
+		c.saveRefreshedState(ctx)
+		return token, nil
+	})
+	if err != nil {
+		return Token{}, fmt.Errorf("client token: %w", err)
+	}
+
+	return token, nil
+}
+
+// userToken returns a valid user token, refreshing it if it has
+// expired or was rejected by the server.
+func (c *Client) userToken(ctx context.Context, rejected string) (Token, error) {
+	if c.config.UserTokenSource != nil {
+		token, err := externalToken(c.config.UserTokenSource)
+		if err != nil {
+			return Token{}, fmt.Errorf("auth token: %w", err)
+		}
+		return token, nil
+	}
+
+	c.mu.Lock()
+	token := c.state.UserToken
+	if token.AccessToken == "" && token.RefreshToken == "" && c.state.GigyaSession == nil {
+		c.mu.Unlock()
+		return Token{}, errors.New("please login before using this endpoint")
+	}
+	if token.AccessToken != rejected && !c.expired(token) {
+		c.mu.Unlock()
+		return token, nil
 	}
-	if time.Now().After(c.state.UserToken.ExpiresAt) {
-		authToken, err := c.refreshToken(ctx, c.state.UserToken)
+
+	logouts := c.logouts
+	token, err := c.flight(ctx, &c.userRefresh, func(ctx context.Context) (Token, error) {
+		t, err := c.refreshToken(ctx, token)
+		var apiErr *APIError
+		if err != nil && (token.RefreshToken == "" || (errors.As(err, &apiErr) && apiErr.grantRejected())) {
+			t, err = c.sessionToken(ctx, err)
+		}
 		if err != nil {
-			return fmt.Errorf("auth token expired: refresh failed: %w", err)
+			return Token{}, err
+		}
+		c.log(ctx, slog.LevelDebug, "refreshed user token", "expires_at", t.Expiry())
+
+		c.mu.Lock()
+		if c.logouts != logouts {
+			c.mu.Unlock()
+			return Token{}, errLoggedOut
 		}
-		c.state.UserToken = authToken
+		c.state.UserToken = t
+		c.mu.Unlock()
+
+		c.saveRefreshedState(ctx)
+		return t, nil
+	})
+	if err != nil {
+		return Token{}, fmt.Errorf("auth token expired: refresh failed: %w", err)
 	}
 
-	req.Header.Add("Authorization", c.state.UserToken.Authorization())
+	return token, nil
+}
 
-	return c.do(ctx, req, v)
+// sessionToken recovers the user token when the refresh token is
+// missing or was rejected (refreshErr), by exchanging a new ID token
+// minted from the Gigya session (see Config.KeepGigyaSession).
+// refreshErr is returned if there is no usable session.
+func (c *Client) sessionToken(ctx context.Context, refreshErr error) (Token, error) {
+	state := c.State()
+	if state.GigyaSession == nil || state.GigyaSession.Expired() || state.IdentityProvider == nil {
+		return Token{}, refreshErr
+	}
+
+	gi := c.gigyaIdentity(*state.IdentityProvider)
+	idToken, err := gi.JWT(ctx, *state.GigyaSession)
+	if err != nil {
+		return Token{}, errors.Join(refreshErr, fmt.Errorf("gigya session: %w", err))
+	}
+
+	token, err := c.tokenExchange(ctx, idToken)
+	if err != nil {
+		return Token{}, errors.Join(refreshErr, fmt.Errorf("gigya session: auth token: %w", err))
+	}
+	c.log(ctx, slog.LevelInfo, "recovered user token via gigya session")
+
+	return token, nil
+}
+
+// errLoggedOut is returned by a token request that completes after
+// Logout, its token is discarded.
+var errLoggedOut = errors.New("logged out")
+
+// tokenFlight is an in-flight token request that concurrent callers
+// can wait on.
+type tokenFlight struct {
+	done  chan struct{}
+	token Token
+	err   error
+}
+
+// flightTimeout limits the duration of a token request started by
+// flight, since it does not end with the context of the caller.
+const flightTimeout = time.Minute
+
+// flight calls fn unless a call is already in flight (tracked by f),
+// in which case it waits for the result of that call instead. It must
+// be called with c.mu held and unlocks it.
+//
+// The call runs in the background with a context that is not canceled
+// with ctx, so that a canceled caller neither fails the other callers
+// nor loses a token the server has already rotated. Every caller
+// still returns early when its own ctx is done.
+func (c *Client) flight(ctx context.Context, f **tokenFlight, fn func(ctx context.Context) (Token, error)) (Token, error) {
+	tf := *f
+	if tf == nil {
+		tf = &tokenFlight{done: make(chan struct{})}
+		*f = tf
+
+		fctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), flightTimeout)
+		go func() {
+			defer cancel()
+
+			tf.token, tf.err = fn(fctx)
+
+			c.mu.Lock()
+			*f = nil
+			c.mu.Unlock()
+			close(tf.done)
+		}()
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return Token{}, ctx.Err()
+	case <-tf.done:
+		return tf.token, tf.err
+	}
 }
 
 func (c *Client) do(ctx context.Context, req *http.Request, v any) error {
@@ -377,13 +1068,19 @@ func (c *Client) do(ctx context.Context, req *http.Request, v any) error {
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		b, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("unexpected status code for %q: %d, body: %s", resp.Request.URL.Path, resp.StatusCode, string(b))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return newAPIError(resp)
+	}
+
+	if v == nil || resp.StatusCode == http.StatusNoContent {
+		return nil // Response body is ignored.
 	}
 
 	err = json.NewDecoder(resp.Body).Decode(v)
 	if err != nil {
+		if errors.Is(err, io.EOF) && resp.StatusCode != http.StatusOK {
+			return nil // Empty body, e.g. 202 Accepted.
+		}
 		return fmt.Errorf("decode response: %w", err)
 	}
 
@@ -391,20 +1088,20 @@ func (c *Client) do(ctx context.Context, req *http.Request, v any) error {
 }
 
 type clientTransport struct {
-	rt     http.RoundTripper
-	apiKey string
-}
-
-func newClientTransport(rt http.RoundTripper, apiKey string) http.RoundTripper {
-	return &clientTransport{rt: rt, apiKey: apiKey}
+	rt        http.RoundTripper
+	apiKey    string
+	userAgent string
+	language  string
 }
 
 func (ct *clientTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrip must not modify the request.
+	req = req.Clone(req.Context())
 	req.Header.Add("x-api-key", ct.apiKey)
-	req.Header.Add("User-Agent", "Ktor client")
+	req.Header.Add("User-Agent", ct.userAgent)
 	req.Header.Add("Accept", "application/json")
 	req.Header.Add("Accept-Charset", "UTF-8")
-	req.Header.Add("Accept-Language", "en-US,en;q=0.9")
+	req.Header.Add("Accept-Language", ct.language)
 
 	// Go's http transport automatically requests gzip.
 	// req.Header.Add("Accept-Encoding", "gzip, deflate, br")
@@ -0,0 +1,468 @@
+package ocpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mafredri/electrolux-ocp/gigya"
+)
+
+// fakeTokenServer is a minimal OCP API that issues and rotates tokens,
+// for testing the token handling of the client.
+type fakeTokenServer struct {
+	*httptest.Server
+
+	requested     chan string   // Receives the grant type of token requests, if set.
+	release       chan struct{} // Token requests wait until closed, if set.
+	refreshStatus int           // Status of refresh_token grants, if set.
+
+	mu           sync.Mutex
+	grants       map[string]int // Token requests by grant type.
+	n            int
+	refreshToken string          // The only valid refresh token.
+	accessTokens map[string]bool // Valid access tokens.
+}
+
+func newFakeTokenServer(t *testing.T) *fakeTokenServer {
+	s := &fakeTokenServer{
+		grants:       make(map[string]int),
+		refreshToken: "refresh-0",
+		accessTokens: make(map[string]bool),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *fakeTokenServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/one-account-authorization/api/v1/token":
+		s.handleToken(w, r)
+	case "/one-account-authorization/api/v1/token/revoke":
+		if s.authorized(w, r) {
+			s.handleRevoke(w, r)
+		}
+	case "/one-account-user/api/v1/countries":
+		if s.authorized(w, r) {
+			fmt.Fprint(w, `[{"countryCode": "FI"}]`)
+		}
+	case "/appliance/api/v2/appliances":
+		if s.authorized(w, r) {
+			fmt.Fprint(w, `[]`)
+		}
+	case "/appliance/api/v2/appliances/info":
+		if s.authorized(w, r) {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"error": "FORBIDDEN", "message": "appliance not owned by user"}`)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *fakeTokenServer) authorized(w http.ResponseWriter, r *http.Request) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.accessTokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")] {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"error": "UNAUTHORIZED"}`)
+		return false
+	}
+	return true
+}
+
+func (s *fakeTokenServer) handleToken(w http.ResponseWriter, r *http.Request) {
+	var tr tokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&tr); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	s.grants[tr.GrantType]++
+	s.mu.Unlock()
+
+	if s.requested != nil {
+		s.requested <- tr.GrantType
+	}
+	if s.release != nil {
+		<-s.release
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.n++
+	token := Token{
+		AccessToken: fmt.Sprintf("access-%d", s.n),
+		ExpiresIn:   3600,
+		TokenType:   "Bearer",
+	}
+	if tr.GrantType == "refresh_token" {
+		if s.refreshStatus != 0 {
+			w.WriteHeader(s.refreshStatus)
+			fmt.Fprint(w, `{"error": "UNAVAILABLE"}`)
+			return
+		}
+		if tr.RefreshToken != s.refreshToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error": "INVALID_GRANT"}`)
+			return
+		}
+		token.RefreshToken = fmt.Sprintf("refresh-%d", s.n)
+		s.refreshToken = token.RefreshToken
+	}
+	s.accessTokens[token.AccessToken] = true
+
+	_ = json.NewEncoder(w).Encode(token)
+}
+
+func (s *fakeTokenServer) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if req.Token == s.refreshToken {
+		s.refreshToken = ""
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *fakeTokenServer) grantCount(grantType string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.grants[grantType]
+}
+
+// newExpiredClient returns a client for the server with an expired
+// user token.
+func newExpiredClient(t *testing.T, s *fakeTokenServer) *Client {
+	c, err := New(Config{
+		APIURL:       s.URL,
+		APIKey:       "api-key",
+		Brand:        "electrolux",
+		ClientID:     "client-id",
+		ClientSecret: "client-secret",
+		CountryCode:  "FI",
+		State: State{
+			RegionalBaseURL: s.URL,
+			UserToken: Token{
+				AccessToken:  "expired",
+				ExpiresAt:    time.Now().Add(-time.Hour),
+				TokenType:    "Bearer",
+				RefreshToken: "refresh-0",
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestClient_ConcurrentUserTokenRefresh(t *testing.T) {
+	s := newFakeTokenServer(t)
+	c := newExpiredClient(t, s)
+
+	const n = 20
+	var wg sync.WaitGroup
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = c.Appliances(context.Background(), false)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("Appliances #%d: %v", i, err)
+		}
+	}
+	if got := s.grantCount("refresh_token"); got != 1 {
+		t.Errorf("refresh requests = %d, want 1", got)
+	}
+	if got, want := c.State().UserToken.RefreshToken, "refresh-1"; got != want {
+		t.Errorf("refresh token = %q, want %q", got, want)
+	}
+}
+
+func TestClient_ConcurrentClientTokenRequest(t *testing.T) {
+	s := newFakeTokenServer(t)
+	c := newExpiredClient(t, s)
+
+	const n = 20
+	var wg sync.WaitGroup
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = c.Countries(context.Background())
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("Countries #%d: %v", i, err)
+		}
+	}
+	if got := s.grantCount("client_credentials"); got != 1 {
+		t.Errorf("client token requests = %d, want 1", got)
+	}
+}
+
+func TestClient_RefreshSurvivesCanceledCaller(t *testing.T) {
+	s := newFakeTokenServer(t)
+	s.requested = make(chan string, 1)
+	s.release = make(chan struct{})
+	c := newExpiredClient(t, s)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leader := make(chan error, 1)
+	go func() {
+		_, err := c.Appliances(ctx, false)
+		leader <- err
+	}()
+	<-s.requested // The refresh is in flight.
+
+	follower := make(chan error, 1)
+	go func() {
+		_, err := c.Appliances(context.Background(), false)
+		follower <- err
+	}()
+
+	cancel()
+	if err := <-leader; !errors.Is(err, context.Canceled) {
+		t.Errorf("leader: got %v, want context.Canceled", err)
+	}
+	close(s.release)
+
+	if err := <-follower; err != nil {
+		t.Errorf("follower: %v", err)
+	}
+	if got := s.grantCount("refresh_token"); got != 1 {
+		t.Errorf("refresh requests = %d, want 1", got)
+	}
+	if got, want := c.State().UserToken.RefreshToken, "refresh-1"; got != want {
+		t.Errorf("refresh token = %q, want %q (rotated token lost)", got, want)
+	}
+}
+
+// newGigyaSessionClient returns a client for the server with an
+// expired user token and a Gigya session, and a counter of the ID
+// tokens minted from the session.
+func newGigyaSessionClient(t *testing.T, s *fakeTokenServer, refreshToken string) (*Client, *atomic.Int32) {
+	var jwtCalls atomic.Int32
+	gs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/accounts.getJWT" || r.FormValue("oauth_token") != "session-token" || r.FormValue("sig") == "" {
+			fmt.Fprint(w, `{"errorCode": 403005, "statusCode": 403, "errorMessage": "Unauthorized user"}`)
+			return
+		}
+		jwtCalls.Add(1)
+		fmt.Fprint(w, `{"errorCode": 0, "statusCode": 200, "id_token": "id-token"}`)
+	}))
+	t.Cleanup(gs.Close)
+
+	c, err := New(Config{
+		APIURL:       s.URL,
+		APIKey:       "api-key",
+		Brand:        "electrolux",
+		ClientID:     "client-id",
+		ClientSecret: "client-secret",
+		CountryCode:  "FI",
+		GigyaBaseURL: gs.URL,
+		State: State{
+			RegionalBaseURL:  s.URL,
+			IdentityProvider: &IdentityProvider{Domain: "eu1.gigya.com", APIKey: "gigya-api-key"},
+			UserToken: Token{
+				AccessToken:  "expired",
+				ExpiresAt:    time.Now().Add(-time.Hour),
+				TokenType:    "Bearer",
+				RefreshToken: refreshToken,
+			},
+			GigyaSession: &gigya.Session{UID: "uid", Token: "session-token", Secret: "c2VjcmV0"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, &jwtCalls
+}
+
+func TestClient_RecoverUserTokenViaGigyaSession(t *testing.T) {
+	s := newFakeTokenServer(t)
+	c, jwtCalls := newGigyaSessionClient(t, s, "revoked")
+
+	if _, err := c.Appliances(context.Background(), false); err != nil {
+		t.Fatalf("Appliances: %v", err)
+	}
+	if got := jwtCalls.Load(); got != 1 {
+		t.Errorf("getJWT calls = %d, want 1", got)
+	}
+	if got := s.grantCount("urn:ietf:params:oauth:grant-type:token-exchange"); got != 1 {
+		t.Errorf("token exchange requests = %d, want 1", got)
+	}
+}
+
+func TestClient_NoGigyaSessionRecoveryOnServerError(t *testing.T) {
+	for _, status := range []int{http.StatusTooManyRequests, http.StatusServiceUnavailable} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			s := newFakeTokenServer(t)
+			s.refreshStatus = status
+			c, jwtCalls := newGigyaSessionClient(t, s, "refresh-0")
+
+			ctx := WithRetryPolicy(context.Background(), RetryPolicy{MaxAttempts: 1})
+			_, err := c.Appliances(ctx, false)
+			if !hasStatusCode(err, status) {
+				t.Errorf("Appliances: got %v, want status %d", err, status)
+			}
+			if got := jwtCalls.Load(); got != 0 {
+				t.Errorf("getJWT calls = %d, want 0", got)
+			}
+			if got := c.State().UserToken.RefreshToken; got != "refresh-0" {
+				t.Errorf("refresh token = %q, want it kept", got)
+			}
+		})
+	}
+}
+
+func TestClient_LogoutRevokesRotatedRefreshToken(t *testing.T) {
+	s := newFakeTokenServer(t)
+	c := newExpiredClient(t, s)
+
+	if err := c.Logout(context.Background()); err != nil {
+		t.Fatalf("Logout: %v", err)
+	}
+	if got := s.grantCount("refresh_token"); got != 1 {
+		t.Errorf("refresh requests = %d, want 1", got)
+	}
+	s.mu.Lock()
+	refreshToken := s.refreshToken
+	s.mu.Unlock()
+	if refreshToken != "" {
+		t.Errorf("refresh token %q was not revoked", refreshToken)
+	}
+	if state := c.State(); state != (State{}) {
+		t.Errorf("state not cleared: %+v", state)
+	}
+}
+
+func TestClient_LogoutRevokesRefreshTokenAfterRejection(t *testing.T) {
+	s := newFakeTokenServer(t)
+	c := newExpiredClient(t, s)
+	c.state.UserToken.ExpiresAt = time.Now().Add(time.Hour) // Valid according to the client.
+
+	if err := c.Logout(context.Background()); err != nil {
+		t.Fatalf("Logout: %v", err)
+	}
+	if got := s.grantCount("refresh_token"); got != 1 {
+		t.Errorf("refresh requests = %d, want 1", got)
+	}
+	s.mu.Lock()
+	refreshToken := s.refreshToken
+	s.mu.Unlock()
+	if refreshToken != "" {
+		t.Errorf("refresh token %q was not revoked", refreshToken)
+	}
+}
+
+func TestClient_ForbiddenDoesNotRefresh(t *testing.T) {
+	s := newFakeTokenServer(t)
+	c := newExpiredClient(t, s)
+	ctx := context.Background()
+
+	if _, err := c.Appliances(ctx, false); err != nil {
+		t.Fatalf("Appliances: %v", err)
+	}
+	_, err := c.AppliancesInfo(ctx, "950011538111111115087076")
+	if !IsForbidden(err) {
+		t.Errorf("AppliancesInfo: got %v, want forbidden", err)
+	}
+	if got := s.grantCount("refresh_token"); got != 1 {
+		t.Errorf("refresh requests = %d, want 1 (permission error is not a rejected token)", got)
+	}
+}
+
+func TestClient_LogoutDiscardsInFlightClientToken(t *testing.T) {
+	s := newFakeTokenServer(t)
+	s.requested = make(chan string, 1)
+	s.release = make(chan struct{})
+	c := newExpiredClient(t, s)
+	c.state.UserToken = Token{}
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := c.Countries(context.Background())
+		errc <- err
+	}()
+	<-s.requested // The client token request is in flight.
+
+	// Logout gives up waiting for the request.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := c.Logout(ctx); err != nil {
+		t.Fatalf("Logout: %v", err)
+	}
+	close(s.release)
+
+	if err := <-errc; !errors.Is(err, errLoggedOut) {
+		t.Errorf("Countries: got %v, want errLoggedOut", err)
+	}
+	if state := c.State(); state != (State{}) {
+		t.Errorf("state written back after Logout: %+v", state)
+	}
+}
+
+func TestClient_LogoutWaitsForInFlightRefresh(t *testing.T) {
+	s := newFakeTokenServer(t)
+	s.requested = make(chan string, 1)
+	s.release = make(chan struct{})
+	c := newExpiredClient(t, s)
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := c.Appliances(context.Background(), false)
+		errc <- err
+	}()
+	<-s.requested // The refresh is in flight.
+
+	logout := make(chan error, 1)
+	go func() { logout <- c.Logout(context.Background()) }()
+	close(s.release)
+
+	if err := <-logout; err != nil {
+		t.Fatalf("Logout: %v", err)
+	}
+	<-errc
+	s.mu.Lock()
+	refreshToken := s.refreshToken
+	s.mu.Unlock()
+	if refreshToken != "" {
+		t.Errorf("rotated refresh token %q was not revoked", refreshToken)
+	}
+	if state := c.State(); state != (State{}) {
+		t.Errorf("state not cleared: %+v", state)
+	}
+}
@@ -0,0 +1,131 @@
+package ocpapi
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Defaults for the HTTP client, see the options for New.
+const (
+	DefaultTimeout   = 30 * time.Second
+	DefaultUserAgent = "Ktor client"
+	DefaultLanguage  = "en-US,en;q=0.9"
+)
+
+// Option configures optional behavior of the Client, see New.
+type Option func(*options)
+
+type options struct {
+	httpClient *http.Client
+	transport  http.RoundTripper
+	timeout    *time.Duration
+	userAgent  string
+	language   string
+	logger     *slog.Logger
+	logBodies  bool
+	now        func() time.Time
+}
+
+// WithHTTPClient sets the HTTP client used as the basis for requests,
+// e.g. for cookie jars or redirect policies. The client is copied and
+// its transport (http.DefaultTransport if nil) is wrapped with the OCP
+// headers, retries and rate limits.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(o *options) {
+		o.httpClient = hc
+	}
+}
+
+// WithTransport sets the base transport, e.g. for proxies or mTLS. It
+// is also used for Gigya requests. Takes precedence over the transport
+// of WithHTTPClient.
+func WithTransport(rt http.RoundTripper) Option {
+	return func(o *options) {
+		o.transport = rt
+	}
+}
+
+// WithTimeout sets the timeout for each request (including retries),
+// zero means no timeout. Defaults to DefaultTimeout.
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.timeout = &d
+	}
+}
+
+// WithUserAgent sets the User-Agent header. Defaults to
+// DefaultUserAgent.
+func WithUserAgent(ua string) Option {
+	return func(o *options) {
+		o.userAgent = ua
+	}
+}
+
+// WithLanguage sets the Accept-Language header, e.g. "fi-FI,fi". This
+// localizes the responses. Defaults to DefaultLanguage.
+func WithLanguage(lang string) Option {
+	return func(o *options) {
+		o.language = lang
+	}
+}
+
+// WithLogger sets the logger used by the client, requests to the OCP
+// and Gigya APIs are logged at debug level. Defaults to no logging.
+func WithLogger(l *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = l
+	}
+}
+
+// WithBodyLogging enables logging of request and response headers and
+// bodies at debug level, see WithLogger. Secrets such as tokens, API
+// keys and passwords are redacted.
+func WithBodyLogging() Option {
+	return func(o *options) {
+		o.logBodies = true
+	}
+}
+
+// WithClock sets the function used to get the current time, e.g. for
+// testing token expiry. Defaults to time.Now.
+func WithClock(now func() time.Time) Option {
+	return func(o *options) {
+		o.now = now
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		userAgent: DefaultUserAgent,
+		language:  DefaultLanguage,
+		now:       time.Now,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// httpClientAndTransport returns the HTTP client and base transport
+// for the options.
+func (o options) httpClientAndTransport() (*http.Client, http.RoundTripper) {
+	hc := &http.Client{Timeout: DefaultTimeout}
+	if o.httpClient != nil {
+		hcCopy := *o.httpClient
+		hc = &hcCopy
+	}
+	if o.timeout != nil {
+		hc.Timeout = *o.timeout
+	}
+
+	rt := hc.Transport
+	if o.transport != nil {
+		rt = o.transport
+	}
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+
+	return hc, rt
+}
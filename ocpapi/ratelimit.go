@@ -0,0 +1,107 @@
+package ocpapi
+
+import (
+	"net/http"
+	"strings"
+
+	"golang.org/x/time/rate"
+)
+
+// EndpointFamily is a group of API endpoints that share a rate limit.
+type EndpointFamily string
+
+// Endpoint families with separate rate limits.
+const (
+	EndpointAuthorization EndpointFamily = "authorization" // /one-account-authorization
+	EndpointUser          EndpointFamily = "user"          // /one-account-user
+	EndpointAppliance     EndpointFamily = "appliance"     // /appliance/api/v2
+)
+
+var endpointFamilyPrefixes = []struct {
+	prefix string
+	family EndpointFamily
+}{
+	{"/one-account-authorization/", EndpointAuthorization},
+	{"/one-account-user/", EndpointUser},
+	{"/appliance/api/v2/", EndpointAppliance},
+}
+
+func endpointFamily(path string) (EndpointFamily, bool) {
+	for _, p := range endpointFamilyPrefixes {
+		if strings.HasPrefix(path, p.prefix) {
+			return p.family, true
+		}
+	}
+	return "", false
+}
+
+// RateLimit is a token bucket rate limit, the zero value means no
+// limit.
+type RateLimit struct {
+	Rate  float64 // Requests per second.
+	Burst int     // Maximum burst size, at least 1 if Rate is set.
+}
+
+// RateLimits are the client-side rate limits per endpoint family.
+type RateLimits struct {
+	Authorization RateLimit
+	User          RateLimit
+	Appliance     RateLimit
+}
+
+// DefaultRateLimits are conservative rate limits that can be used with
+// Config.RateLimits.
+var DefaultRateLimits = RateLimits{
+	Authorization: RateLimit{Rate: 1, Burst: 5},
+	User:          RateLimit{Rate: 2, Burst: 10},
+	Appliance:     RateLimit{Rate: 5, Burst: 10},
+}
+
+type rateLimiters map[EndpointFamily]*rate.Limiter
+
+func newRateLimiters(rl RateLimits) rateLimiters {
+	limiters := make(rateLimiters)
+	for family, l := range map[EndpointFamily]RateLimit{
+		EndpointAuthorization: rl.Authorization,
+		EndpointUser:          rl.User,
+		EndpointAppliance:     rl.Appliance,
+	} {
+		if l.Rate <= 0 {
+			continue
+		}
+		limiters[family] = rate.NewLimiter(rate.Limit(l.Rate), max(l.Burst, 1))
+	}
+	return limiters
+}
+
+// RateLimitBudget returns the number of requests that can currently be
+// made without waiting, per rate limited endpoint family.
+func (c *Client) RateLimitBudget() map[EndpointFamily]float64 {
+	budget := make(map[EndpointFamily]float64, len(c.limiters))
+	for family, l := range c.limiters {
+		budget[family] = l.Tokens()
+	}
+	return budget
+}
+
+type rateLimitTransport struct {
+	rt       http.RoundTripper
+	limiters rateLimiters
+}
+
+func newRateLimitTransport(rt http.RoundTripper, limiters rateLimiters) http.RoundTripper {
+	return &rateLimitTransport{rt: rt, limiters: limiters}
+}
+
+// RoundTrip waits for the rate limit of the endpoint family (if any),
+// or until the request context is canceled.
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if family, ok := endpointFamily(req.URL.Path); ok {
+		if l := t.limiters[family]; l != nil {
+			if err := l.Wait(req.Context()); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return t.rt.RoundTrip(req)
+}
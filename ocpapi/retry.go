@@ -0,0 +1,173 @@
+package ocpapi
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/mafredri/electrolux-ocp/internal/httplog"
+)
+
+// RetryPolicy controls how requests are retried on transient errors,
+// i.e. 429 Too Many Requests, 5xx server errors and network errors
+// such as timeouts and dropped connections.
+type RetryPolicy struct {
+	MaxAttempts int           // Including the first attempt, 1 (or less) disables retries.
+	MinBackoff  time.Duration // Backoff before the first retry, doubled for each retry.
+	MaxBackoff  time.Duration // Maximum backoff, also for Retry-After (longer is not retried).
+
+	// RetryNonIdempotent enables retries for requests that are not
+	// idempotent (e.g. POST). By default only idempotent requests are
+	// retried.
+	RetryNonIdempotent bool
+}
+
+// DefaultRetryPolicy is the retry policy used when none is configured.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	MinBackoff:  500 * time.Millisecond,
+	MaxBackoff:  10 * time.Second,
+}
+
+type retryPolicyKey struct{}
+
+// WithRetryPolicy returns a context that overrides the retry policy of
+// the client for requests made with it.
+func WithRetryPolicy(ctx context.Context, p RetryPolicy) context.Context {
+	return context.WithValue(ctx, retryPolicyKey{}, p)
+}
+
+type retryTransport struct {
+	rt     http.RoundTripper
+	policy RetryPolicy
+}
+
+func newRetryTransport(rt http.RoundTripper, policy RetryPolicy) http.RoundTripper {
+	return &retryTransport{rt: rt, policy: policy}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	p := t.policy
+	if cp, ok := ctx.Value(retryPolicyKey{}).(RetryPolicy); ok {
+		p = cp
+	}
+	canReplay := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	if p.MaxAttempts <= 1 || !canReplay || (!p.RetryNonIdempotent && !isIdempotent(req)) {
+		return t.rt.RoundTrip(req)
+	}
+
+	backoff := p.MinBackoff
+	for attempt := 1; ; attempt++ {
+		r := req
+		if attempt > 1 {
+			r = req.Clone(httplog.WithAttempt(ctx, attempt))
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				r.Body = body
+			}
+		}
+
+		resp, err := t.rt.RoundTrip(r)
+		if attempt >= p.MaxAttempts || !shouldRetry(ctx, resp, err) {
+			return resp, err
+		}
+
+		wait := jitter(backoff)
+		if resp != nil {
+			if ra, ok := retryAfter(resp.Header.Get("Retry-After")); ok {
+				// Return the response (e.g. 429, see IsRateLimited)
+				// rather than waiting longer than the policy or the
+				// request allows.
+				if deadline, ok := ctx.Deadline(); ra > p.MaxBackoff || (ok && ra > time.Until(deadline)) {
+					return resp, nil
+				}
+				wait = max(wait, ra)
+			}
+			// Drain (up to a limit) to allow connection reuse.
+			_, _ = io.CopyN(io.Discard, resp.Body, 64<<10)
+			resp.Body.Close()
+		}
+		backoff = min(backoff*2, p.MaxBackoff)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// isIdempotent reports whether the request is idempotent as defined
+// by RFC 9110 (or has an idempotency key).
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != ""
+}
+
+func shouldRetry(ctx context.Context, resp *http.Response, err error) bool {
+	if err != nil {
+		return ctx.Err() == nil && isTransient(err)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// isTransient reports whether the transport error is likely to be
+// transient, e.g. a timeout or a dropped connection. Errors such as an
+// unsupported protocol scheme or an invalid certificate are not.
+func isTransient(err error) bool {
+	var certErr *tls.CertificateVerificationError
+	var authErr x509.UnknownAuthorityError
+	var hostErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	if errors.As(err, &certErr) || errors.As(err, &authErr) || errors.As(err, &hostErr) || errors.As(err, &invalidErr) {
+		return false
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return false
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET)
+}
+
+// retryAfter parses the Retry-After header value (seconds or date).
+func retryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if s, err := strconv.Atoi(v); err == nil {
+		return time.Duration(s) * time.Second, s >= 0
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t), true
+	}
+	return 0, false
+}
@@ -0,0 +1,267 @@
+package ocpapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyServer responds with the status codes in order (200 once they
+// have been used up), with the Retry-After header if set.
+func flakyServer(t *testing.T, retryAfter string, codes ...int) (*httptest.Server, *atomic.Int32) {
+	var n atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i := int(n.Add(1)) - 1
+		if i < len(codes) {
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			w.WriteHeader(codes[i])
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &n
+}
+
+var testRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	MinBackoff:  time.Millisecond,
+	MaxBackoff:  10 * time.Millisecond,
+}
+
+func roundTrip(t *testing.T, rt http.RoundTripper, ctx context.Context, method, url string) (*http.Response, error) {
+	t.Helper()
+
+	req, err := http.NewRequestWithContext(ctx, method, url, strings.NewReader("body"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := rt.RoundTrip(req)
+	if err == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	return resp, err
+}
+
+func TestRetryTransport_ServerErrors(t *testing.T) {
+	srv, n := flakyServer(t, "", http.StatusServiceUnavailable, http.StatusBadGateway)
+	rt := newRetryTransport(http.DefaultTransport, testRetryPolicy)
+
+	resp, err := roundTrip(t, rt, context.Background(), http.MethodGet, srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want 200", resp.StatusCode)
+	}
+	if got := n.Load(); got != 3 {
+		t.Errorf("attempts = %d, want 3", got)
+	}
+}
+
+func TestRetryTransport_MaxAttempts(t *testing.T) {
+	srv, n := flakyServer(t, "", 500, 500, 500, 500)
+	rt := newRetryTransport(http.DefaultTransport, testRetryPolicy)
+
+	resp, err := roundTrip(t, rt, context.Background(), http.MethodGet, srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", resp.StatusCode)
+	}
+	if got := n.Load(); got != 3 {
+		t.Errorf("attempts = %d, want 3", got)
+	}
+}
+
+func TestRetryTransport_RetryAfter(t *testing.T) {
+	p := testRetryPolicy
+	p.MaxBackoff = 2 * time.Second
+
+	t.Run("honored", func(t *testing.T) {
+		srv, n := flakyServer(t, "1", http.StatusTooManyRequests)
+		rt := newRetryTransport(http.DefaultTransport, p)
+
+		start := time.Now()
+		resp, err := roundTrip(t, rt, context.Background(), http.MethodGet, srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("status = %d, want 200", resp.StatusCode)
+		}
+		if got := n.Load(); got != 2 {
+			t.Errorf("attempts = %d, want 2", got)
+		}
+		if d := time.Since(start); d < time.Second {
+			t.Errorf("retried after %v, want Retry-After (1s) to be honored", d)
+		}
+	})
+
+	for _, tt := range []struct {
+		name       string
+		retryAfter string
+		timeout    time.Duration
+	}{
+		{"exceeds MaxBackoff", "60", 0},
+		{"exceeds deadline", "1", 100 * time.Millisecond},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, n := flakyServer(t, tt.retryAfter, http.StatusTooManyRequests)
+			rt := newRetryTransport(http.DefaultTransport, p)
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+
+			resp, err := roundTrip(t, rt, ctx, http.MethodGet, srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != http.StatusTooManyRequests {
+				t.Errorf("status = %d, want 429", resp.StatusCode)
+			}
+			if got := n.Load(); got != 1 {
+				t.Errorf("attempts = %d, want 1", got)
+			}
+		})
+	}
+
+	t.Run("rate limit error", func(t *testing.T) {
+		srv, _ := flakyServer(t, "5", http.StatusTooManyRequests)
+		c, err := New(Config{
+			APIURL:       srv.URL,
+			APIKey:       "api-key",
+			Brand:        "electrolux",
+			ClientID:     "client-id",
+			ClientSecret: "client-secret",
+			CountryCode:  "FI",
+		}, WithTimeout(time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Retry-After is within DefaultRetryPolicy.MaxBackoff, but
+		// exceeds the timeout.
+		start := time.Now()
+		err = c.do(context.Background(), req, nil)
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || !IsRateLimited(err) {
+			t.Errorf("do: got %v, want rate limit *APIError", err)
+		}
+		if d := time.Since(start); d > 500*time.Millisecond {
+			t.Errorf("returned after %v, want without waiting for Retry-After", d)
+		}
+	})
+}
+
+func TestRetryTransport_NonIdempotent(t *testing.T) {
+	srv, n := flakyServer(t, "", http.StatusServiceUnavailable)
+	rt := newRetryTransport(http.DefaultTransport, testRetryPolicy)
+
+	resp, err := roundTrip(t, rt, context.Background(), http.MethodPost, srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want 503", resp.StatusCode)
+	}
+	if got := n.Load(); got != 1 {
+		t.Errorf("attempts = %d, want 1 (POST is not retried)", got)
+	}
+
+	// Overridden per call.
+	p := testRetryPolicy
+	p.RetryNonIdempotent = true
+	n.Store(0)
+	resp, err = roundTrip(t, rt, WithRetryPolicy(context.Background(), p), http.MethodPost, srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want 200", resp.StatusCode)
+	}
+	if got := n.Load(); got != 2 {
+		t.Errorf("attempts = %d, want 2", got)
+	}
+}
+
+type countingRoundTripper struct {
+	rt http.RoundTripper
+	n  atomic.Int32
+}
+
+func (c *countingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.n.Add(1)
+	return c.rt.RoundTrip(req)
+}
+
+func TestRetryTransport_NetworkErrors(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	closedURL := srv.URL
+	srv.Close()
+
+	tlsSrv := httptest.NewUnstartedServer(http.NotFoundHandler())
+	tlsSrv.Config.ErrorLog = log.New(io.Discard, "", 0) // Handshake errors are expected.
+	tlsSrv.StartTLS()
+	t.Cleanup(tlsSrv.Close)
+
+	for _, tt := range []struct {
+		name     string
+		url      string
+		attempts int32
+	}{
+		{"connection refused", closedURL, 3},
+		{"unsupported protocol scheme", "ftp://127.0.0.1/", 1},
+		{"unknown certificate authority", tlsSrv.URL, 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			crt := &countingRoundTripper{rt: http.DefaultTransport}
+			rt := newRetryTransport(crt, testRetryPolicy)
+
+			_, err := roundTrip(t, rt, context.Background(), http.MethodGet, tt.url)
+			if err == nil {
+				t.Fatal("want error")
+			}
+			if got := crt.n.Load(); got != tt.attempts {
+				t.Errorf("attempts = %d, want %d (error: %v)", got, tt.attempts, err)
+			}
+		})
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want bool
+	}{
+		{io.ErrUnexpectedEOF, true},
+		{fmt.Errorf("read: %w", io.EOF), true},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{&net.DNSError{Err: "no such host", IsNotFound: true}, false},
+		{errors.New(`unsupported protocol scheme ""`), false},
+	} {
+		if got := isTransient(tt.err); got != tt.want {
+			t.Errorf("isTransient(%v) = %t, want %t", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package ocpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// StateStore persists the client state (e.g. auth tokens) so that
+// it survives restarts.
+type StateStore interface {
+	// Load returns the saved state, or the zero State if no state
+	// has been saved yet.
+	Load() (State, error)
+	// Save persists the state, it is called by the client whenever
+	// the state changes.
+	Save(State) error
+}
+
+// FileStateStore is a StateStore that keeps the state as JSON in a
+// file. Writes are atomic and the file is only readable by the owner.
+type FileStateStore struct {
+	path string
+}
+
+var _ StateStore = (*FileStateStore)(nil)
+
+// NewFileStateStore returns a StateStore backed by the file at path.
+func NewFileStateStore(path string) *FileStateStore {
+	return &FileStateStore{path: path}
+}
+
+// Load implements StateStore.
+func (s *FileStateStore) Load() (State, error) {
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return State{}, nil
+		}
+		return State{}, err
+	}
+
+	var state State
+	err = json.Unmarshal(b, &state)
+	if err != nil {
+		return State{}, fmt.Errorf("unmarshal: %w", err)
+	}
+
+	return state, nil
+}
+
+// Save implements StateStore.
+func (s *FileStateStore) Save(state State) error {
+	b, err := json.MarshalIndent(state, "", "\t")
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+
+	return writeFileAtomic(s.path, b, 0o600)
+}
+
+// writeFileAtomic writes data to a temporary file in the same
+// directory as path and renames it into place.
+func writeFileAtomic(path string, data []byte, perm fs.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // Cleanup on failure, no-op after rename.
+
+	err = f.Chmod(perm)
+	if err == nil {
+		_, err = f.Write(data)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,456 @@
+// Package ocptest implements an in-process fake Electrolux OCP API
+// server for testing ocpapi.Client without real credentials.
+//
+// The server enforces the API key, bearer tokens and token expiry, so
+// the authentication logic of the client is exercised. Gigya is not
+// faked, log in using ocpapi.Client.LoginWithIDToken with IDToken.
+package ocptest
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mafredri/electrolux-ocp/ocpapi"
+)
+
+// Credentials accepted by the server.
+const (
+	APIKey       = "test-api-key"
+	Brand        = "electrolux"
+	ClientID     = "TestClient"
+	ClientSecret = "test-client-secret"
+	CountryCode  = "FI"
+	IDToken      = "test-id-token" // Accepted by the token exchange.
+)
+
+// DefaultTokenLifetime is the default lifetime of issued access tokens.
+const DefaultTokenLifetime = time.Hour
+
+// Server is a fake OCP API server.
+type Server struct {
+	*httptest.Server
+
+	mu            sync.Mutex
+	now           func() time.Time
+	tokenLifetime time.Duration
+	tokens        map[string]token // By access token.
+	refreshTokens map[string]bool
+	appliances    []*appliance
+}
+
+type token struct {
+	user      bool // User token (vs. client credentials).
+	expiresAt time.Time
+}
+
+type appliance struct {
+	appliance ocpapi.Appliance
+	info      ocpapi.ApplianceInfo
+}
+
+// Option configures the server.
+type Option func(*Server)
+
+// WithClock sets the function used to get the current time, e.g. to
+// test token expiry.
+func WithClock(now func() time.Time) Option {
+	return func(s *Server) {
+		s.now = now
+	}
+}
+
+// WithTokenLifetime sets the lifetime of issued access tokens.
+func WithTokenLifetime(d time.Duration) Option {
+	return func(s *Server) {
+		s.tokenLifetime = d
+	}
+}
+
+// NewServer starts and returns a new server, Close must be called
+// when done.
+func NewServer(opts ...Option) *Server {
+	s := &Server{
+		now:           time.Now,
+		tokenLifetime: DefaultTokenLifetime,
+		tokens:        make(map[string]token),
+		refreshTokens: make(map[string]bool),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Config returns a client config for the server.
+func (s *Server) Config() ocpapi.Config {
+	return ocpapi.Config{
+		APIURL:       s.URL,
+		APIKey:       APIKey,
+		Brand:        Brand,
+		ClientID:     ClientID,
+		ClientSecret: ClientSecret,
+		CountryCode:  CountryCode,
+	}
+}
+
+// AddAppliance adds an appliance to the account.
+func (s *Server) AddAppliance(a ocpapi.Appliance, info ocpapi.ApplianceInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.appliances = append(s.appliances, &appliance{appliance: a, info: info})
+}
+
+// Appliance returns the current state of the appliance.
+func (s *Server) Appliance(id ocpapi.ApplianceID) (ocpapi.Appliance, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if a := s.appliance(id); a != nil {
+		return a.appliance, true
+	}
+	return ocpapi.Appliance{}, false
+}
+
+// UpdateReported mutates the reported state of the appliance.
+func (s *Server) UpdateReported(id ocpapi.ApplianceID, fn func(*ocpapi.Reported)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a := s.appliance(id)
+	if a == nil {
+		return fmt.Errorf("appliance %q not found", id)
+	}
+	fn(&a.appliance.Properties.Reported)
+	return nil
+}
+
+// ExpireTokens expires all issued access tokens, requests using them
+// are rejected with 401 Unauthorized. Refresh tokens stay valid.
+func (s *Server) ExpireTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k, t := range s.tokens {
+		t.expiresAt = s.now()
+		s.tokens[k] = t
+	}
+}
+
+// RevokeRefreshTokens revokes all issued refresh tokens.
+func (s *Server) RevokeRefreshTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	clear(s.refreshTokens)
+}
+
+func (s *Server) appliance(id ocpapi.ApplianceID) *appliance {
+	for _, a := range s.appliances {
+		if a.appliance.ApplianceID == id {
+			return a
+		}
+	}
+	return nil
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("x-api-key") != APIKey {
+		writeError(w, http.StatusForbidden, "FORBIDDEN", "invalid api key")
+		return
+	}
+
+	path := r.URL.Path
+	switch {
+	case path == "/one-account-authorization/api/v1/token" && r.Method == http.MethodPost:
+		s.handleToken(w, r)
+	case path == "/one-account-authorization/api/v1/token/revoke" && r.Method == http.MethodPost:
+		s.auth(w, r, true, s.handleRevoke)
+	case path == "/one-account-user/api/v1/identity-providers" && r.Method == http.MethodGet:
+		s.auth(w, r, false, s.handleIdentityProviders)
+	case path == "/one-account-user/api/v1/countries" && r.Method == http.MethodGet:
+		s.auth(w, r, false, s.handleCountries)
+	case path == "/appliance/api/v2/appliances" && r.Method == http.MethodGet:
+		s.auth(w, r, true, s.handleAppliances)
+	case path == "/appliance/api/v2/appliances/info" && r.Method == http.MethodPost:
+		s.auth(w, r, true, s.handleAppliancesInfo)
+	case strings.HasPrefix(path, "/appliance/api/v2/appliances/"):
+		id, command, _ := strings.Cut(strings.TrimPrefix(path, "/appliance/api/v2/appliances/"), "/")
+		switch {
+		case command == "" && r.Method == http.MethodGet:
+			s.auth(w, r, true, func(w http.ResponseWriter, r *http.Request) {
+				s.handleAppliance(w, r, ocpapi.ApplianceID(id))
+			})
+		case command == "command" && r.Method == http.MethodPut:
+			s.auth(w, r, true, func(w http.ResponseWriter, r *http.Request) {
+				s.handleCommand(w, r, ocpapi.ApplianceID(id))
+			})
+		default:
+			writeError(w, http.StatusNotFound, "NOT_FOUND", "not found")
+		}
+	default:
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "not found")
+	}
+}
+
+// auth checks the bearer token before calling next, user endpoints
+// require a user token.
+func (s *Server) auth(w http.ResponseWriter, r *http.Request, user bool, next http.HandlerFunc) {
+	accessToken, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "missing bearer token")
+		return
+	}
+
+	s.mu.Lock()
+	t, ok := s.tokens[accessToken]
+	expired := !s.now().Before(t.expiresAt)
+	s.mu.Unlock()
+
+	switch {
+	case !ok:
+		writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "invalid token")
+	case expired:
+		writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "token expired")
+	case user && !t.user:
+		writeError(w, http.StatusForbidden, "FORBIDDEN", "user token required")
+	default:
+		next(w, r)
+	}
+}
+
+type tokenRequest struct {
+	GrantType    string `json:"grantType"`
+	ClientID     string `json:"clientId"`
+	ClientSecret string `json:"clientSecret"`
+	IDToken      string `json:"idToken"`
+	RefreshToken string `json:"refreshToken"`
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	var tr tokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&tr); err != nil {
+		writeError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
+		return
+	}
+	if tr.ClientID != ClientID {
+		writeError(w, http.StatusBadRequest, "INVALID_CLIENT", "unknown client id")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch tr.GrantType {
+	case "client_credentials":
+		if tr.ClientSecret != ClientSecret {
+			writeError(w, http.StatusUnauthorized, "INVALID_CLIENT", "invalid client secret")
+			return
+		}
+		writeJSON(w, http.StatusOK, s.issue(false))
+	case "urn:ietf:params:oauth:grant-type:token-exchange":
+		if tr.IDToken != IDToken {
+			writeError(w, http.StatusUnauthorized, "INVALID_GRANT", "invalid id token")
+			return
+		}
+		writeJSON(w, http.StatusOK, s.issue(true))
+	case "refresh_token":
+		if !s.refreshTokens[tr.RefreshToken] {
+			writeError(w, http.StatusUnauthorized, "INVALID_GRANT", "invalid refresh token")
+			return
+		}
+		delete(s.refreshTokens, tr.RefreshToken) // Rotated.
+		writeJSON(w, http.StatusOK, s.issue(true))
+	default:
+		writeError(w, http.StatusBadRequest, "UNSUPPORTED_GRANT_TYPE", tr.GrantType)
+	}
+}
+
+// issue issues a new access token (and refresh token for users), it
+// must be called with s.mu held.
+func (s *Server) issue(user bool) map[string]any {
+	now := s.now()
+	expiresAt := now.Add(s.tokenLifetime)
+	scope := ""
+	if user {
+		scope = "email offline_access eluxiot:*:*:*"
+	}
+
+	claims, _ := json.Marshal(map[string]any{
+		"sub":     randomID(),
+		"iss":     s.URL,
+		"iat":     now.Unix(),
+		"exp":     expiresAt.Unix(),
+		"scope":   scope,
+		"country": CountryCode,
+		"brand":   Brand,
+	})
+	accessToken := "eyJhbGciOiJub25lIn0." + base64.RawURLEncoding.EncodeToString(claims) + "." + randomID()
+	s.tokens[accessToken] = token{user: user, expiresAt: expiresAt}
+
+	res := map[string]any{
+		"accessToken": accessToken,
+		"expiresIn":   int(s.tokenLifetime.Seconds()),
+		"tokenType":   "Bearer",
+		"scope":       scope,
+	}
+	if user {
+		refreshToken := randomID()
+		s.refreshTokens[refreshToken] = true
+		res["refreshToken"] = refreshToken
+	}
+	return res
+}
+
+func (s *Server) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	delete(s.refreshTokens, req.Token)
+	s.mu.Unlock()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleIdentityProviders(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, []ocpapi.IdentityProvider{{
+		Domain:                   "eu1.gigya.invalid",
+		APIKey:                   "test-gigya-api-key",
+		Brand:                    r.URL.Query().Get("brand"),
+		HTTPRegionalBaseURL:      s.URL,
+		WebSocketRegionalBaseURL: "ws" + strings.TrimPrefix(s.URL, "http"),
+		DataCenter:               "EU",
+	}})
+}
+
+func (s *Server) handleCountries(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, []ocpapi.Country{{
+		Name:           "Finland",
+		CountryCode:    CountryCode,
+		LegalRegion:    "EU (GDPR)",
+		BusinessRegion: "BA-EU",
+		DataCenter:     "EU",
+	}})
+}
+
+func (s *Server) handleAppliances(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	appliances := make([]ocpapi.Appliance, 0, len(s.appliances))
+	for _, a := range s.appliances {
+		appliances = append(appliances, a.appliance)
+	}
+	writeJSON(w, http.StatusOK, appliances)
+}
+
+func (s *Server) handleAppliancesInfo(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ApplianceIDs []ocpapi.ApplianceID `json:"applianceIds"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info := []ocpapi.ApplianceInfo{}
+	for _, id := range req.ApplianceIDs {
+		if a := s.appliance(id); a != nil {
+			info = append(info, a.info)
+		}
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+func (s *Server) handleAppliance(w http.ResponseWriter, r *http.Request, id ocpapi.ApplianceID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a := s.appliance(id)
+	if a == nil {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "appliance not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, a.appliance)
+}
+
+// handleCommand applies the command (e.g. {"Workmode": "Manual"}) to
+// the reported state of the appliance.
+func (s *Server) handleCommand(w http.ResponseWriter, r *http.Request, id ocpapi.ApplianceID) {
+	var command map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&command); err != nil {
+		writeError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a := s.appliance(id)
+	if a == nil {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "appliance not found")
+		return
+	}
+
+	reported := &a.appliance.Properties.Reported
+	b, err := json.Marshal(reported)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", err.Error())
+		return
+	}
+	var state map[string]json.RawMessage
+	if err = json.Unmarshal(b, &state); err != nil {
+		writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", err.Error())
+		return
+	}
+	for k, v := range command {
+		state[k] = v
+	}
+	b, err = json.Marshal(state)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", err.Error())
+		return
+	}
+	var updated ocpapi.Reported
+	if err = json.Unmarshal(b, &updated); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_COMMAND", err.Error())
+		return
+	}
+	*reported = updated
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, errCode, message string) {
+	writeJSON(w, code, ocpapi.ErrorResponse{Code: errCode, Message: message})
+}
+
+func randomID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,149 @@
+package ocptest_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mafredri/electrolux-ocp/ocpapi"
+	"github.com/mafredri/electrolux-ocp/ocptest"
+)
+
+const applianceID = ocpapi.ApplianceID("950011538111111115087076")
+
+// clock is a settable clock for WithClock.
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *clock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+func newServer(t *testing.T, opts ...ocptest.Option) (*ocptest.Server, ocpapi.Token) {
+	s := ocptest.NewServer(opts...)
+	t.Cleanup(s.Close)
+
+	var a ocpapi.Appliance
+	a.ApplianceID = applianceID
+	a.Properties.Reported.Workmode = "Auto"
+	s.AddAppliance(a, ocpapi.ApplianceInfo{})
+
+	c, err := ocpapi.New(s.Config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.LoginWithIDToken(context.Background(), "user@example.com", ocptest.IDToken); err != nil {
+		t.Fatal(err)
+	}
+	return s, c.State().UserToken
+}
+
+func do(t *testing.T, method, url, apiKey string, token ocpapi.Token, body string) int {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("x-api-key", apiKey)
+	if token.AccessToken != "" {
+		req.Header.Set("Authorization", token.Authorization())
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestServer_Auth(t *testing.T) {
+	s, token := newServer(t)
+	url := s.URL + "/appliance/api/v2/appliances/" + applianceID.String()
+
+	if got := do(t, http.MethodGet, url, ocptest.APIKey, token, ""); got != http.StatusOK {
+		t.Errorf("status = %d, want 200", got)
+	}
+	if got := do(t, http.MethodGet, url, "invalid", token, ""); got != http.StatusForbidden {
+		t.Errorf("status with invalid api key = %d, want 403", got)
+	}
+	if got := do(t, http.MethodGet, url, ocptest.APIKey, ocpapi.Token{}, ""); got != http.StatusUnauthorized {
+		t.Errorf("status without token = %d, want 401", got)
+	}
+
+	c, err := ocpapi.New(s.Config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientToken, err := c.ClientTokenSource().Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := do(t, http.MethodGet, url, ocptest.APIKey, ocpapi.TokenFromOAuth2(clientToken), ""); got != http.StatusForbidden {
+		t.Errorf("status with client token = %d, want 403", got)
+	}
+}
+
+func TestServer_TokenExpiry(t *testing.T) {
+	clk := &clock{now: time.Now()}
+	s, token := newServer(t, ocptest.WithClock(clk.Now), ocptest.WithTokenLifetime(time.Hour))
+	url := s.URL + "/appliance/api/v2/appliances"
+
+	claims, err := token.Claims()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := clk.Now().Add(time.Hour).Unix(); claims.ExpiresAt.Unix() != want {
+		t.Errorf("exp = %v, want %v", claims.ExpiresAt.Unix(), want)
+	}
+
+	clk.Add(59 * time.Minute)
+	if got := do(t, http.MethodGet, url, ocptest.APIKey, token, ""); got != http.StatusOK {
+		t.Errorf("status = %d, want 200", got)
+	}
+	clk.Add(time.Minute)
+	if got := do(t, http.MethodGet, url, ocptest.APIKey, token, ""); got != http.StatusUnauthorized {
+		t.Errorf("status after expiry = %d, want 401", got)
+	}
+}
+
+func TestServer_Command(t *testing.T) {
+	s, token := newServer(t)
+	url := s.URL + "/appliance/api/v2/appliances/" + applianceID.String() + "/command"
+
+	if got := do(t, http.MethodPut, url, ocptest.APIKey, token, `{"Workmode": "Manual", "Fanspeed": 5}`); got != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202", got)
+	}
+	a, ok := s.Appliance(applianceID)
+	if !ok {
+		t.Fatal("appliance not found")
+	}
+	if r := a.Properties.Reported; r.Workmode != "Manual" || r.Fanspeed != 5 {
+		t.Errorf("reported = %+v, want command applied", r)
+	}
+
+	if got := do(t, http.MethodPut, url, ocptest.APIKey, token, `{"Fanspeed": "fast"}`); got != http.StatusBadRequest {
+		t.Errorf("status with invalid command = %d, want 400", got)
+	}
+	if got := do(t, http.MethodPut, s.URL+"/appliance/api/v2/appliances/unknown/command", ocptest.APIKey, token, `{}`); got != http.StatusNotFound {
+		t.Errorf("status for unknown appliance = %d, want 404", got)
+	}
+	if err := s.UpdateReported("unknown", func(*ocpapi.Reported) {}); err == nil {
+		t.Error("UpdateReported for unknown appliance: want error")
+	}
+}